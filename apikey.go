@@ -0,0 +1,185 @@
+package gotils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const apiKeyContextKey = contextKey("api_key")
+
+// Middleware wraps an ErrorHandlerFunc, such as to check auth before calling it.
+type Middleware func(ErrorHandlerFunc) ErrorHandlerFunc
+
+// APIKey is a stored API key. Only the hash of the key is kept, see HashAPIKey.
+type APIKey struct {
+	// ID identifies the key in logs without exposing it.
+	ID   string `json:"id"`
+	Hash string `json:"hash"`
+	// Scopes the key has. "*" grants all scopes.
+	Scopes []string `json:"scopes"`
+	// ExpiresAt, if set, is when the key stops working.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// HasScope returns whether the key has scope.
+func (k *APIKey) HasScope(scope string) bool {
+	return contains(k.Scopes, scope) || contains(k.Scopes, "*")
+}
+
+// APIKeyStore looks up API keys by hash. LookupAPIKey should return ErrNotFound for unknown keys.
+type APIKeyStore interface {
+	LookupAPIKey(ctx context.Context, hash string) (*APIKey, error)
+}
+
+// HashAPIKey returns the hex SHA-256 hash of key, which is what's stored and looked up.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAPIKey returns a new random key to give to the client and its hash to store.
+func GenerateAPIKey() (key, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = base64.RawURLEncoding.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
+
+// MemoryAPIKeyStore is an APIKeyStore in memory, handy for tests and keys loaded from config.
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*APIKey
+}
+
+// NewMemoryAPIKeyStore returns a store with keys in it.
+func NewMemoryAPIKeyStore(keys ...*APIKey) *MemoryAPIKeyStore {
+	s := &MemoryAPIKeyStore{keys: map[string]*APIKey{}}
+	for _, k := range keys {
+		s.Add(k)
+	}
+	return s
+}
+
+// Add adds or replaces k.
+func (s *MemoryAPIKeyStore) Add(k *APIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[k.Hash] = k
+}
+
+// Remove removes the key with hash.
+func (s *MemoryAPIKeyStore) Remove(hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, hash)
+}
+
+// LookupAPIKey returns the key with hash or ErrNotFound.
+func (s *MemoryAPIKeyStore) LookupAPIKey(ctx context.Context, hash string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.keys[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return k, nil
+}
+
+// APIKeyAuth is middleware that authenticates requests with an API key in a header. The key is put
+// on the context, see APIKeyFromContext, and its ID is added to the context fields as "api_key_id".
+// Missing or unknown keys get a 401 UserError.
+//
+//	auth := &gotils.APIKeyAuth{Store: store}
+//	http.Handle("/orders", gotils.ErrorHandler(auth.Wrap(gotils.RequireScope("orders:write")(createOrder))))
+type APIKeyAuth struct {
+	Store APIKeyStore
+	// Header the key is sent in, defaults to X-Api-Key.
+	Header string
+}
+
+func (a *APIKeyAuth) header() string {
+	if a.Header == "" {
+		return "X-Api-Key"
+	}
+	return a.Header
+}
+
+// Authenticate looks up the key on r.
+func (a *APIKeyAuth) Authenticate(r *http.Request) (*APIKey, error) {
+	key := r.Header.Get(a.header())
+	if key == "" {
+		return nil, CodedUserErrorf(http.StatusUnauthorized, nil, "missing API key")
+	}
+	k, err := a.Store.LookupAPIKey(r.Context(), HashAPIKey(key))
+	if errors.Is(err, ErrNotFound) {
+		return nil, CodedUserErrorf(http.StatusUnauthorized, nil, "invalid API key")
+	}
+	if err != nil {
+		return nil, C(r.Context()).Errorf("couldn't look up API key: %w", err)
+	}
+	if !k.ExpiresAt.IsZero() && time.Now().After(k.ExpiresAt) {
+		return nil, CodedUserErrorf(http.StatusUnauthorized, nil, "API key expired")
+	}
+	return k, nil
+}
+
+// Wrap returns a handler that authenticates the request before calling h.
+func (a *APIKeyAuth) Wrap(h ErrorHandlerFunc) ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		k, err := a.Authenticate(r)
+		if err != nil {
+			return err
+		}
+		ctx := context.WithValue(r.Context(), apiKeyContextKey, k)
+		ctx = With(ctx, "api_key_id", k.ID)
+		return h(w, r.WithContext(ctx))
+	}
+}
+
+// Handler returns an http.Handler that authenticates the request before calling h, responding with
+// the error if it fails.
+func (a *APIKeyAuth) Handler(h http.Handler) http.Handler {
+	return ErrorHandler(a.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		h.ServeHTTP(w, r)
+		return nil
+	}))
+}
+
+// APIKeyFromContext returns the key put on the context by APIKeyAuth, or nil.
+func APIKeyFromContext(ctx context.Context) *APIKey {
+	k, _ := ctx.Value(apiKeyContextKey).(*APIKey)
+	return k
+}
+
+// RequireScope returns Middleware that only calls the handler if the request was authenticated with
+// all of scopes, by APIKeyAuth or JWTVerifier. Unauthenticated requests get a 401 UserError, and
+// ones without the scopes a 403.
+func RequireScope(scopes ...string) Middleware {
+	return func(h ErrorHandlerFunc) ErrorHandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			var has func(string) bool
+			if k := APIKeyFromContext(r.Context()); k != nil {
+				has = k.HasScope
+			} else if c := ClaimsFromContext(r.Context()); c != nil {
+				has = func(s string) bool { return contains(c.Scopes(), s) }
+			} else {
+				return CodedUserErrorf(http.StatusUnauthorized, nil, "not authenticated")
+			}
+			for _, s := range scopes {
+				if !has(s) {
+					return CodedUserErrorf(http.StatusForbidden, nil, "missing scope %q", s)
+				}
+			}
+			return h(w, r)
+		}
+	}
+}
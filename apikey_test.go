@@ -0,0 +1,59 @@
+package gotils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	key, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryAPIKeyStore(
+		&APIKey{ID: "svc1", Hash: hash, Scopes: []string{"orders:read"}},
+		&APIKey{ID: "admin", Hash: HashAPIKey("admin-key"), Scopes: []string{"*"}},
+	)
+	auth := &APIKeyAuth{Store: store}
+	ts := httptest.NewServer(ErrorHandler(auth.Wrap(RequireScope("orders:read")(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method == "POST" {
+			return RequireScope("orders:write")(func(w http.ResponseWriter, r *http.Request) error {
+				return WriteObject(w, 201, map[string]any{})
+			})(w, r)
+		}
+		return WriteObject(w, 200, map[string]any{"id": Fields(r.Context())["api_key_id"]})
+	}))))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := NewClient(ts.URL)
+	out := map[string]any{}
+	if err := c.GetJSON(ctx, "/", &out, &RequestOptions{Auth: APIKeyHeader("X-Api-Key", key)}); err != nil {
+		t.Fatal(err)
+	}
+	if out["id"] != "svc1" {
+		t.Error("expected api_key_id field, got", out)
+	}
+	if err := c.PostJSON(ctx, "/", nil, nil, &RequestOptions{Auth: APIKeyHeader("X-Api-Key", "admin-key")}); err != nil {
+		t.Error("expected admin to have all scopes, got", err)
+	}
+
+	for want, opts := range map[int]*RequestOptions{
+		401: {Auth: APIKeyHeader("X-Api-Key", "wrong")},
+		403: {Auth: APIKeyHeader("X-Api-Key", key)},
+	} {
+		err := c.PostJSON(ctx, "/", nil, nil, opts)
+		var ue UserError
+		var he HTTPError
+		if !errors.As(err, &he) || he.Code() != want || !errors.As(err, &ue) {
+			t.Errorf("expected %v UserError, got %v", want, err)
+		}
+	}
+	var he HTTPError
+	if err := c.GetJSON(ctx, "/", nil, nil); !errors.As(err, &he) || he.Code() != 401 || he.Error() != "missing API key" {
+		t.Error("expected 401 for a missing key, got", err)
+	}
+}
@@ -0,0 +1,206 @@
+package gotils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	urlp "net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticator adds credentials to outgoing requests. Set Client.Auth or RequestOptions.Auth to use one.
+//
+//	c := &gotils.Client{BaseURL: url, Auth: gotils.BearerToken(token)}
+type Authenticator interface {
+	// Authenticate adds credentials to req. It's called for every attempt, so it should set rather
+	// than add headers.
+	Authenticate(ctx context.Context, req *http.Request) error
+}
+
+// Invalidator is implemented by Authenticators with cached credentials. If a request gets a 401,
+// the client calls Invalidate and tries once more with fresh credentials.
+type Invalidator interface {
+	Invalidate()
+}
+
+// AuthenticatorFunc lets a function be used as an Authenticator.
+type AuthenticatorFunc func(ctx context.Context, req *http.Request) error
+
+// Authenticate calls f.
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, req *http.Request) error {
+	return f(ctx, req)
+}
+
+// BearerToken sends a static token in the Authorization header.
+func BearerToken(token string) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// BasicAuth uses HTTP basic authentication.
+func BasicAuth(username, password string) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, req *http.Request) error {
+		req.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+// APIKeyHeader sends key in the header name, such as X-Api-Key.
+func APIKeyHeader(name, key string) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, req *http.Request) error {
+		req.Header.Set(name, key)
+		return nil
+	})
+}
+
+// APIKeyQuery sends key in the query parameter param.
+func APIKeyQuery(param, key string) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, req *http.Request) error {
+		q := req.URL.Query()
+		q.Set(param, key)
+		req.URL.RawQuery = q.Encode()
+		return nil
+	})
+}
+
+// OAuth2ClientCredentials gets access tokens with the OAuth2 client credentials grant and sends them
+// as bearer tokens. Tokens are cached and refreshed in the background before they expire.
+//
+//	c := &gotils.Client{Auth: &gotils.OAuth2ClientCredentials{
+//		TokenURL:     "https://auth.example.com/oauth/token",
+//		ClientID:     id,
+//		ClientSecret: secret,
+//		Scopes:       []string{"read"},
+//	}}
+type OAuth2ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Params are extra form values for the token request, such as audience.
+	Params map[string]string
+	// CredentialsInBody sends the client ID and secret as form values instead of with basic auth,
+	// for servers that don't support basic auth.
+	CredentialsInBody bool
+	// RefreshBefore is how long before expiry to fetch a new token. Defaults to 1 minute.
+	RefreshBefore time.Duration
+	// Client is used to fetch tokens, defaults to DefaultClient.
+	Client *Client
+
+	mu         sync.Mutex
+	token      string
+	expiry     time.Time
+	refreshing bool
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Authenticate sets the Authorization header to a valid access token.
+func (o *OAuth2ClientCredentials) Authenticate(ctx context.Context, req *http.Request) error {
+	token, err := o.Token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Invalidate drops the cached token so the next request fetches a new one.
+func (o *OAuth2ClientCredentials) Invalidate() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.token = ""
+}
+
+func (o *OAuth2ClientCredentials) refreshBefore() time.Duration {
+	if o.RefreshBefore <= 0 {
+		return time.Minute
+	}
+	return o.RefreshBefore
+}
+
+// Token returns a valid access token, fetching a new one if needed. If the cached token is close to
+// expiring, it's returned while a new one is fetched in the background.
+func (o *OAuth2ClientCredentials) Token(ctx context.Context) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	if o.token != "" && (o.expiry.IsZero() || now.Before(o.expiry)) {
+		if !o.expiry.IsZero() && now.After(o.expiry.Add(-o.refreshBefore())) && !o.refreshing {
+			o.refreshing = true
+			go func() {
+				token, expiry, err := o.fetch(CopyCtxWithoutCancel(ctx))
+				o.mu.Lock()
+				defer o.mu.Unlock()
+				o.refreshing = false
+				if err != nil {
+					L(ctx).Error().Printf("couldn't refresh OAuth2 token: %v", err)
+					return
+				}
+				o.token, o.expiry = token, expiry
+			}()
+		}
+		return o.token, nil
+	}
+	token, expiry, err := o.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	o.token, o.expiry = token, expiry
+	return token, nil
+}
+
+// fetch gets a new token from the token endpoint, returning it and when it expires.
+func (o *OAuth2ClientCredentials) fetch(ctx context.Context) (string, time.Time, error) {
+	form := urlp.Values{"grant_type": {"client_credentials"}}
+	if len(o.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.Scopes, " "))
+	}
+	for k, v := range o.Params {
+		form.Set(k, v)
+	}
+	opts := withHeader(nil, "Content-Type", "application/x-www-form-urlencoded")
+	if o.CredentialsInBody {
+		form.Set("client_id", o.ClientID)
+		form.Set("client_secret", o.ClientSecret)
+		// don't use the client's own Auth, which may well be o
+		opts.Auth = AuthenticatorFunc(func(ctx context.Context, req *http.Request) error { return nil })
+	} else {
+		opts.Auth = BasicAuth(urlp.QueryEscape(o.ClientID), urlp.QueryEscape(o.ClientSecret))
+	}
+	c := o.Client
+	if c == nil {
+		c = DefaultClient
+	}
+	resp, err := c.send(ctx, o.TokenURL, http.MethodPost, strings.NewReader(form.Encode()), opts)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("couldn't get OAuth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("couldn't read OAuth2 token: %w", err)
+	}
+	tr := &tokenResponse{}
+	if err := json.Unmarshal(b, tr); err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid OAuth2 token response: %w", err)
+	}
+	if tr.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("OAuth2 token response has no access_token")
+	}
+	var expiry time.Time
+	if tr.ExpiresIn > 0 {
+		expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return tr.AccessToken, expiry, nil
+}
@@ -0,0 +1,109 @@
+package gotils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAuth(t *testing.T) {
+	var tokens, revoked int32
+	ts := httptest.NewServer(ErrorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		switch r.URL.Path {
+		case "/token":
+			id, secret, ok := r.BasicAuth()
+			if !ok || id != "client" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "a b" {
+				return WriteError(w, 401, errors.New("invalid_client"))
+			}
+			n := atomic.AddInt32(&tokens, 1)
+			return WriteObject(w, 200, map[string]any{"access_token": fmt.Sprint("token", n), "token_type": "bearer", "expires_in": 3600})
+		case "/oauth":
+			if r.Header.Get("Authorization") != fmt.Sprint("Bearer token", atomic.LoadInt32(&tokens)) || atomic.LoadInt32(&revoked) == 1 {
+				atomic.StoreInt32(&revoked, 0)
+				return WriteError(w, 401, errors.New("invalid token"))
+			}
+		case "/bearer":
+			if r.Header.Get("Authorization") != "Bearer abc" {
+				return WriteError(w, 401, errors.New("nope"))
+			}
+		case "/basic":
+			if u, p, _ := r.BasicAuth(); u != "bob" || p != "pw" {
+				return WriteError(w, 401, errors.New("nope"))
+			}
+		case "/key":
+			if r.Header.Get("X-Api-Key") != "k" {
+				return WriteError(w, 401, errors.New("nope"))
+			}
+		case "/query":
+			if r.URL.Query().Get("key") != "k" {
+				return WriteError(w, 401, errors.New("nope"))
+			}
+		}
+		return WriteObject(w, 200, map[string]any{"ok": true})
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := NewClient(ts.URL)
+	for path, auth := range map[string]Authenticator{
+		"/bearer": BearerToken("abc"),
+		"/basic":  BasicAuth("bob", "pw"),
+		"/key":    APIKeyHeader("X-Api-Key", "k"),
+		"/query":  APIKeyQuery("key", "k"),
+	} {
+		if err := c.GetJSON(ctx, path, nil, &RequestOptions{Auth: auth}); err != nil {
+			t.Error(path, err)
+		}
+		if err := c.GetJSON(ctx, path, nil, nil); err == nil {
+			t.Error(path, "expected an error without auth")
+		}
+	}
+
+	c.Auth = &OAuth2ClientCredentials{TokenURL: ts.URL + "/token", ClientID: "client", ClientSecret: "s3cret", Scopes: []string{"a", "b"}}
+	for i := 0; i < 3; i++ {
+		if err := c.PostJSON(ctx, "/oauth", map[string]any{"i": i}, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tokens != 1 {
+		t.Errorf("expected the token to be cached, got %v tokens", tokens)
+	}
+	// server revokes the token, client should get a new one and retry
+	atomic.StoreInt32(&revoked, 1)
+	if err := c.PostJSON(ctx, "/oauth", map[string]any{"i": 4}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 2 {
+		t.Errorf("expected a new token after a 401, got %v tokens", tokens)
+	}
+
+	// tokens close to expiry are refreshed in the background
+	o := &OAuth2ClientCredentials{TokenURL: ts.URL + "/token", ClientID: "client", ClientSecret: "s3cret", Scopes: []string{"a", "b"}, RefreshBefore: 2 * time.Hour}
+	first, err := o.Token(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok, _ := o.Token(ctx); tok != first {
+		t.Error("expected the current token while refreshing")
+	}
+	refreshed := false
+	for i := 0; i < 100 && !refreshed; i++ {
+		time.Sleep(10 * time.Millisecond)
+		tok, _ := o.Token(ctx)
+		refreshed = tok != first
+	}
+	if !refreshed {
+		t.Error("expected a refreshed token")
+	}
+
+	c.Auth = &OAuth2ClientCredentials{TokenURL: ts.URL + "/token", ClientID: "client", ClientSecret: "wrong"}
+	var he HTTPError
+	if err := c.GetJSON(ctx, "/oauth", nil, nil); !errors.As(err, &he) || he.Code() != 401 {
+		t.Error("expected 401 from the token endpoint, got", err)
+	}
+}
@@ -0,0 +1,244 @@
+package gotils
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker for a host.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all requests through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails requests immediately with a CircuitOpenError.
+	BreakerOpen
+	// BreakerHalfOpen lets a few probe requests through to see if the host has recovered.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// CircuitOpenError is returned by the client while the breaker for a host is open. It's an HTTPError
+// with code 503.
+type CircuitOpenError struct {
+	Host string
+	// RetryAt is when the breaker will let a probe request through again.
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open for %v", e.Host)
+}
+
+func (e *CircuitOpenError) Code() int {
+	return http.StatusServiceUnavailable
+}
+
+// CircuitBreaker stops sending requests to a host that keeps failing, so callers fail fast instead
+// of waiting on timeouts. Set it on Client.Breaker, each host gets its own state. Zero values use defaults.
+//
+// A request counts as a failure if it couldn't get a response at all or the response was a 5xx.
+type CircuitBreaker struct {
+	// FailureRate is the fraction of failed requests within Window that opens the breaker. Defaults to 0.5.
+	FailureRate float64
+	// MinRequests is the minimum number of requests within Window before the breaker can open. Defaults to 10.
+	MinRequests int
+	// Window is the rolling window failures are counted over. Defaults to 1 minute.
+	Window time.Duration
+	// Cooldown is how long the breaker stays open before letting probes through. Defaults to 30 seconds.
+	Cooldown time.Duration
+	// HalfOpenProbes is the number of requests let through while half-open, all of which must
+	// succeed to close the breaker again. Defaults to 1.
+	HalfOpenProbes int
+	// OnStateChange is called whenever a host's breaker changes state, useful for logging and metrics.
+	// It's called while holding a lock so keep it quick.
+	OnStateChange func(host string, from, to BreakerState)
+
+	mu    sync.Mutex
+	hosts map[string]*hostBreaker
+}
+
+const breakerBuckets = 10
+
+type breakerBucket struct {
+	start  int64 // bucket number, see hostBreaker.bucket
+	ok     int
+	failed int
+}
+
+type hostBreaker struct {
+	state    BreakerState
+	openedAt time.Time
+	buckets  [breakerBuckets]breakerBucket
+	// half-open bookkeeping
+	probes    int
+	successes int
+}
+
+// State returns the current state of the breaker for host.
+func (cb *CircuitBreaker) State(host string) BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	hb := cb.hosts[host]
+	if hb == nil {
+		return BreakerClosed
+	}
+	if hb.state == BreakerOpen && time.Since(hb.openedAt) >= cb.cooldown() {
+		return BreakerHalfOpen
+	}
+	return hb.state
+}
+
+func (cb *CircuitBreaker) failureRate() float64 {
+	if cb.FailureRate <= 0 {
+		return 0.5
+	}
+	return cb.FailureRate
+}
+
+func (cb *CircuitBreaker) minRequests() int {
+	if cb.MinRequests <= 0 {
+		return 10
+	}
+	return cb.MinRequests
+}
+
+func (cb *CircuitBreaker) window() time.Duration {
+	if cb.Window <= 0 {
+		return time.Minute
+	}
+	return cb.Window
+}
+
+func (cb *CircuitBreaker) cooldown() time.Duration {
+	if cb.Cooldown <= 0 {
+		return 30 * time.Second
+	}
+	return cb.Cooldown
+}
+
+func (cb *CircuitBreaker) halfOpenProbes() int {
+	if cb.HalfOpenProbes <= 0 {
+		return 1
+	}
+	return cb.HalfOpenProbes
+}
+
+func (cb *CircuitBreaker) setState(host string, hb *hostBreaker, to BreakerState) {
+	from := hb.state
+	hb.state = to
+	hb.probes = 0
+	hb.successes = 0
+	switch to {
+	case BreakerOpen:
+		hb.openedAt = time.Now()
+	case BreakerClosed:
+		hb.buckets = [breakerBuckets]breakerBucket{}
+	}
+	if from != to && cb.OnStateChange != nil {
+		cb.OnStateChange(host, from, to)
+	}
+}
+
+// allow returns a CircuitOpenError if the request to host shouldn't go through. If it returns nil,
+// done must be called with the outcome.
+func (cb *CircuitBreaker) allow(host string) error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.hosts == nil {
+		cb.hosts = map[string]*hostBreaker{}
+	}
+	hb := cb.hosts[host]
+	if hb == nil {
+		hb = &hostBreaker{}
+		cb.hosts[host] = hb
+	}
+	switch hb.state {
+	case BreakerOpen:
+		if time.Since(hb.openedAt) < cb.cooldown() {
+			return &CircuitOpenError{Host: host, RetryAt: hb.openedAt.Add(cb.cooldown())}
+		}
+		cb.setState(host, hb, BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if hb.probes >= cb.halfOpenProbes() {
+			return &CircuitOpenError{Host: host, RetryAt: time.Now().Add(cb.cooldown())}
+		}
+		hb.probes++
+	}
+	return nil
+}
+
+// done records the outcome of a request that was allowed. ignore is for outcomes that say nothing
+// about the host's health, such as the caller canceling.
+func (cb *CircuitBreaker) done(host string, failed, ignore bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	hb := cb.hosts[host]
+	if hb == nil {
+		return
+	}
+	switch hb.state {
+	case BreakerHalfOpen:
+		if ignore {
+			hb.probes--
+			return
+		}
+		if failed {
+			cb.setState(host, hb, BreakerOpen)
+			return
+		}
+		hb.successes++
+		if hb.successes >= cb.halfOpenProbes() {
+			cb.setState(host, hb, BreakerClosed)
+		}
+	case BreakerClosed:
+		if ignore {
+			return
+		}
+		b := hb.bucket(cb.window())
+		if failed {
+			b.failed++
+		} else {
+			b.ok++
+		}
+		total, failures := hb.counts(cb.window())
+		if total >= cb.minRequests() && float64(failures)/float64(total) >= cb.failureRate() {
+			cb.setState(host, hb, BreakerOpen)
+		}
+	}
+}
+
+// bucket returns the bucket for the current time, resetting it if it's stale.
+func (hb *hostBreaker) bucket(window time.Duration) *breakerBucket {
+	n := time.Now().UnixNano() / int64(window/breakerBuckets)
+	b := &hb.buckets[n%breakerBuckets]
+	if b.start != n {
+		*b = breakerBucket{start: n}
+	}
+	return b
+}
+
+func (hb *hostBreaker) counts(window time.Duration) (total, failures int) {
+	n := time.Now().UnixNano() / int64(window/breakerBuckets)
+	for _, b := range hb.buckets {
+		if n-b.start < breakerBuckets {
+			total += b.ok + b.failed
+			failures += b.failed
+		}
+	}
+	return total, failures
+}
@@ -0,0 +1,66 @@
+package gotils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var healthy int32
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			WriteError(w, 500, errors.New("down"))
+			return
+		}
+		WriteMessage(w, 200, "ok")
+	}))
+	defer ts.Close()
+
+	var changes []BreakerState
+	c := &Client{Breaker: &CircuitBreaker{
+		MinRequests: 3,
+		Cooldown:    50 * time.Millisecond,
+		OnStateChange: func(host string, from, to BreakerState) {
+			changes = append(changes, to)
+		},
+	}}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		c.GetJSON(ctx, ts.URL, nil, nil)
+	}
+	err := c.GetJSON(ctx, ts.URL, nil, nil)
+	var coe *CircuitOpenError
+	if !errors.As(err, &coe) {
+		t.Fatal("expected CircuitOpenError, got", err)
+	}
+	var he HTTPError
+	if !errors.As(err, &he) || he.Code() != 503 {
+		t.Error("expected a 503 HTTPError")
+	}
+	if calls != 3 {
+		t.Error("expected open breaker to not call the server, got calls:", calls)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(60 * time.Millisecond)
+	err = c.GetJSON(ctx, ts.URL, nil, nil)
+	if err != nil {
+		t.Fatal("expected probe to succeed, got", err)
+	}
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(changes) != len(want) {
+		t.Fatalf("expected state changes %v, got %v", want, changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("expected state changes %v, got %v", want, changes)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package gotils
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotCached is returned by CachingTransport when the request has Cache-Control: only-if-cached and
+// there's no fresh response cached.
+var ErrNotCached = errors.New("response not in cache")
+
+// Cache stores responses for CachingTransport.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+// MemoryCache is an in-memory Cache that evicts the least recently used entries.
+type MemoryCache struct {
+	maxEntries int
+	mu         sync.Mutex
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key   string
+	value []byte
+}
+
+// NewMemoryCache returns a MemoryCache holding up to maxEntries responses. 0 means no limit.
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{maxEntries: maxEntries, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+// Get returns the value for key.
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*memoryCacheEntry).value, true
+}
+
+// Set stores value for key, evicting the least recently used entry if the cache is full.
+func (c *MemoryCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*memoryCacheEntry).value = value
+		return
+	}
+	c.items[key] = c.ll.PushFront(&memoryCacheEntry{key: key, value: value})
+	if c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.items, e.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// Delete removes key.
+func (c *MemoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+// Len returns the number of entries.
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// DiskCache is a Cache that stores each entry in a file in a directory, so it survives restarts.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a DiskCache in dir, creating it if needed.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+func (c *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get returns the value for key.
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// Set stores value for key. It writes to a temp file and renames it so readers never see a partial entry.
+func (c *DiskCache) Set(key string, value []byte) {
+	f, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// Delete removes key.
+func (c *DiskCache) Delete(key string) {
+	os.Remove(c.path(key))
+}
+
+// CachingTransport is an http.RoundTripper that caches GET responses following the HTTP caching
+// rules (RFC 9111) for a private cache: max-age, Expires, no-store, no-cache and stale-while-revalidate
+// are honored, and stale responses are revalidated with If-None-Match and If-Modified-Since. A 304
+// reuses the cached body, so callers just see the original response. Responses to requests with
+// credentials are only cached if they're marked public, s-maxage or must-revalidate, as they would
+// be by a shared cache, since the cache is keyed on the URL alone.
+//
+//	c := &gotils.Client{HTTPClient: &http.Client{Transport: &gotils.CachingTransport{Cache: gotils.NewMemoryCache(1000)}}}
+//
+// Responses served from the cache have the X-From-Cache header set to 1.
+type CachingTransport struct {
+	// Cache defaults to an unlimited MemoryCache.
+	Cache Cache
+	// Next is the transport that sends the request, defaults to http.DefaultTransport.
+	Next http.RoundTripper
+
+	once         sync.Once
+	mu           sync.Mutex
+	revalidating map[string]bool
+}
+
+// cachedResponse is what's stored in the Cache.
+type cachedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	// Vary has the request headers named by the response's Vary header.
+	Vary http.Header `json:"vary,omitempty"`
+	// Time is when the response was received.
+	Time time.Time `json:"time"`
+}
+
+func (t *CachingTransport) init() {
+	t.once.Do(func() {
+		if t.Cache == nil {
+			t.Cache = NewMemoryCache(0)
+		}
+		t.revalidating = map[string]bool{}
+	})
+}
+
+func (t *CachingTransport) next() http.RoundTripper {
+	if t.Next != nil {
+		return t.Next
+	}
+	return http.DefaultTransport
+}
+
+// RoundTrip serves req from the cache if it can, otherwise sends it with Next and caches the response.
+func (t *CachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.init()
+	key := req.URL.String()
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			// unsafe methods invalidate the cache for the URL
+			t.Cache.Delete(key)
+		}
+		return t.next().RoundTrip(req)
+	}
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		return t.next().RoundTrip(req)
+	}
+
+	_, onlyIfCached := reqCC["only-if-cached"]
+	cached := t.get(key, req)
+	if cached == nil {
+		if onlyIfCached {
+			return nil, fmt.Errorf("%w: %v", ErrNotCached, key)
+		}
+		return t.fetch(req, key, nil)
+	}
+	_, noCache := reqCC["no-cache"]
+	age, fresh, swr := cached.freshness(time.Now())
+	if !noCache {
+		if age < fresh {
+			return cached.response(req, age), nil
+		}
+		if age < fresh+swr {
+			resp := cached.response(req, age)
+			t.revalidate(req, key, cached)
+			return resp, nil
+		}
+	}
+	if onlyIfCached {
+		return nil, fmt.Errorf("%w: %v", ErrNotCached, key)
+	}
+	return t.fetch(req, key, cached)
+}
+
+func (t *CachingTransport) get(key string, req *http.Request) *cachedResponse {
+	b, ok := t.Cache.Get(key)
+	if !ok {
+		return nil
+	}
+	cached := &cachedResponse{}
+	if err := json.Unmarshal(b, cached); err != nil {
+		t.Cache.Delete(key)
+		return nil
+	}
+	for k, v := range cached.Vary {
+		if strings.Join(req.Header.Values(k), ", ") != strings.Join(v, ", ") {
+			return nil
+		}
+	}
+	return cached
+}
+
+// fetch sends req, conditionally if there's a cached response, and caches the result.
+func (t *CachingTransport) fetch(req *http.Request, key string, cached *cachedResponse) (*http.Response, error) {
+	if cached != nil {
+		req = req.Clone(req.Context())
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lm := cached.Header.Get("Last-Modified"); lm != "" {
+			req.Header.Set("If-Modified-Since", lm)
+		}
+	}
+	resp, err := t.next().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		for k, v := range resp.Header {
+			cached.Header[k] = v
+		}
+		cached.Header.Del("Age")
+		cached.Time = now
+		t.store(key, cached)
+		return cached.response(req, 0), nil
+	}
+	if !cacheable(req, resp) {
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	entry := &cachedResponse{Status: resp.StatusCode, Header: resp.Header.Clone(), Body: body, Time: now}
+	for _, v := range resp.Header.Values("Vary") {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				if entry.Vary == nil {
+					entry.Vary = http.Header{}
+				}
+				entry.Vary[http.CanonicalHeaderKey(k)] = req.Header.Values(k)
+			}
+		}
+	}
+	t.store(key, entry)
+	return resp, nil
+}
+
+func (t *CachingTransport) store(key string, cached *cachedResponse) {
+	b, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	t.Cache.Set(key, b)
+}
+
+// revalidate refreshes a stale response in the background, once at a time per URL.
+func (t *CachingTransport) revalidate(req *http.Request, key string, cached *cachedResponse) {
+	t.mu.Lock()
+	if t.revalidating[key] {
+		t.mu.Unlock()
+		return
+	}
+	t.revalidating[key] = true
+	t.mu.Unlock()
+	req = req.Clone(CopyCtxWithoutCancel(req.Context()))
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			delete(t.revalidating, key)
+			t.mu.Unlock()
+		}()
+		resp, err := t.fetch(req, key, cached)
+		if err != nil {
+			L(req.Context()).Info().Printf("couldn't revalidate %v: %v", key, err)
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+}
+
+// freshness returns the current age of the response, how long it's fresh for and how long after
+// that it can be served while revalidating.
+func (c *cachedResponse) freshness(now time.Time) (age, fresh, swr time.Duration) {
+	age = now.Sub(c.Time)
+	if a, err := strconv.Atoi(c.Header.Get("Age")); err == nil && a > 0 {
+		age += time.Duration(a) * time.Second
+	}
+	cc := parseCacheControl(c.Header)
+	if _, ok := cc["no-cache"]; ok {
+		return age, 0, 0
+	}
+	if v, ok := cc["max-age"]; ok {
+		if s, err := strconv.Atoi(v); err == nil {
+			fresh = time.Duration(s) * time.Second
+		}
+	} else if exp := c.Header.Get("Expires"); exp != "" {
+		expires, err := http.ParseTime(exp)
+		if err == nil {
+			date, err := http.ParseTime(c.Header.Get("Date"))
+			if err != nil {
+				date = c.Time
+			}
+			fresh = expires.Sub(date)
+		}
+	}
+	if v, ok := cc["stale-while-revalidate"]; ok {
+		if s, err := strconv.Atoi(v); err == nil {
+			swr = time.Duration(s) * time.Second
+		}
+	}
+	return age, fresh, swr
+}
+
+func (c *cachedResponse) response(req *http.Request, age time.Duration) *http.Response {
+	h := c.Header.Clone()
+	h.Set("Age", strconv.Itoa(int(age.Seconds())))
+	h.Set("X-From-Cache", "1")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", c.Status, http.StatusText(c.Status)),
+		StatusCode:    c.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// cacheable returns whether resp can be stored. Only responses that can be reused, with an explicit
+// lifetime or a validator, are worth storing.
+func cacheable(req *http.Request, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+	default:
+		return false
+	}
+	cc := parseCacheControl(resp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if resp.Header.Get("Vary") == "*" {
+		return false
+	}
+	if authorized(req) {
+		// the cache is keyed on the URL and a client can be shared by callers with different
+		// credentials, so only keep responses the server says can be shared (RFC 9111 section 3.5)
+		_, public := cc["public"]
+		_, sMaxAge := cc["s-maxage"]
+		_, mustRevalidate := cc["must-revalidate"]
+		if !public && !sMaxAge && !mustRevalidate {
+			return false
+		}
+	}
+	_, maxAge := cc["max-age"]
+	_, noCache := cc["no-cache"]
+	return maxAge || noCache || resp.Header.Get("Expires") != "" || resp.Header.Get("ETag") != "" ||
+		resp.Header.Get("Last-Modified") != ""
+}
+
+// authorized returns whether req has credentials: an Authorization, Cookie or X-Api-Key header.
+func authorized(req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "" || req.Header.Get("X-Api-Key") != ""
+}
+
+// parseCacheControl parses the Cache-Control header into directives and their values.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := map[string]string{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			k, v, _ := strings.Cut(d, "=")
+			cc[strings.ToLower(strings.TrimSpace(k))] = strings.Trim(strings.TrimSpace(v), `"`)
+		}
+	}
+	return cc
+}
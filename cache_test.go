@@ -0,0 +1,133 @@
+package gotils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachingTransport(t *testing.T) {
+	var hits, notModified int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				atomic.AddInt32(&notModified, 1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/swr":
+			w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		WriteObject(w, 200, &clientThing{Name: r.URL.Path})
+	}))
+	defer ts.Close()
+
+	dc, err := NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cache := range []Cache{NewMemoryCache(10), dc} {
+		atomic.StoreInt32(&hits, 0)
+		atomic.StoreInt32(&notModified, 0)
+		ctx := context.Background()
+		c := &Client{BaseURL: ts.URL, HTTPClient: &http.Client{Transport: &CachingTransport{Cache: cache}}}
+		get := func(path string) {
+			t.Helper()
+			out := &clientThing{}
+			if err := c.GetJSON(ctx, path, out, nil); err != nil {
+				t.Fatal(err)
+			}
+			if out.Name != path {
+				t.Errorf("expected %v, got %v", path, out.Name)
+			}
+		}
+		expectHits := func(want int32) {
+			t.Helper()
+			if got := atomic.LoadInt32(&hits); got != want {
+				t.Errorf("%T: expected %v requests to the server, got %v", cache, want, got)
+			}
+		}
+
+		get("/fresh")
+		get("/fresh")
+		expectHits(1)
+
+		get("/etag")
+		get("/etag")
+		expectHits(3)
+		if atomic.LoadInt32(&notModified) != 1 {
+			t.Error("expected a 304")
+		}
+
+		get("/nostore")
+		get("/nostore")
+		expectHits(5)
+
+		get("/swr")
+		get("/swr") // served stale, revalidated in the background
+		for i := 0; i < 100 && atomic.LoadInt32(&hits) < 7; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		expectHits(7)
+
+		// the client asked for a fresh copy
+		err := c.GetJSON(ctx, "/fresh", nil, &RequestOptions{Headers: map[string]string{"Cache-Control": "no-cache"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectHits(8)
+	}
+
+	mc := NewMemoryCache(2)
+	mc.Set("a", []byte("a"))
+	mc.Set("b", []byte("b"))
+	mc.Get("a")
+	mc.Set("c", []byte("c"))
+	if _, ok := mc.Get("b"); ok || mc.Len() != 2 {
+		t.Error("expected b to be evicted")
+	}
+}
+
+func TestCachingTransportAuthorized(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		WriteObject(w, 200, &clientThing{Name: r.Header.Get("Authorization")})
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := &Client{BaseURL: ts.URL, HTTPClient: &http.Client{Transport: &CachingTransport{}}}
+	get := func(path, token string) string {
+		t.Helper()
+		out := &clientThing{}
+		if err := c.GetJSON(ctx, path, out, &RequestOptions{Auth: BearerToken(token)}); err != nil {
+			t.Fatal(err)
+		}
+		return out.Name
+	}
+	// one caller's private response isn't served to another
+	get("/private", "alice")
+	if got := get("/private", "bob"); got != "Bearer bob" {
+		t.Errorf("expected bob's own response, got %q", got)
+	}
+	// unless the server says it can be shared
+	get("/public", "alice")
+	if got := get("/public", "bob"); got != "Bearer alice" {
+		t.Errorf("expected the cached public response, got %q", got)
+	}
+}
@@ -0,0 +1,488 @@
+package gotils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client is a reusable HTTP client for JSON APIs. The zero value is usable and behaves like the
+// package level functions (which all use DefaultClient).
+//
+//	c := &gotils.Client{
+//		BaseURL:   "https://api.example.com/v1",
+//		Headers:   map[string]string{"Authorization": "Bearer " + token},
+//		UserAgent: "myapp/1.0",
+//		Timeout:   10 * time.Second,
+//	}
+//	err := c.GetJSON(ctx, "/users/123", user, nil)
+type Client struct {
+	// BaseURL is prepended to any relative URL passed to the methods.
+	BaseURL string
+	// HTTPClient is the underlying client. Defaults to http.DefaultClient. Set this to change the
+	// transport, proxy or TLS config.
+	HTTPClient *http.Client
+	// Headers are added to every request. Headers in RequestOptions take precedence.
+	Headers map[string]string
+	// UserAgent sets the User-Agent header if not empty.
+	UserAgent string
+	// Timeout for each request including reading the response body. 0 means no timeout.
+	// RequestOptions.Timeout overrides this.
+	Timeout time.Duration
+	// Retry enables automatic retries for all requests. RequestOptions.Retry overrides this.
+	Retry *RetryPolicy
+	// Breaker, if set, fails requests fast with a CircuitOpenError when a host keeps failing.
+	Breaker *CircuitBreaker
+	// Limiter, if set, rate limits requests per host. RequestOptions.Limiter overrides this.
+	Limiter *RateLimiter
+	// Resolver maps schemes such as ipfs:// to HTTP gateways. Defaults to DefaultResolver.
+	Resolver *Resolver
+	// Auth adds credentials to every request. RequestOptions.Auth overrides this.
+	Auth Authenticator
+	// Sign signs every request. RequestOptions.Sign overrides this.
+	Sign *HMACSigner
+}
+
+// DefaultClient is used by all the package level functions such as Do, GetJSON and PostJSON.
+var DefaultClient = &Client{}
+
+// NewClient returns a Client for the given base URL.
+func NewClient(baseURL string) *Client {
+	return &Client{BaseURL: baseURL}
+}
+
+func (c *Client) httpClient() *http.Client {
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return hc
+}
+
+func (c *Client) timeout(opts *RequestOptions) time.Duration {
+	if opts != nil && opts.Timeout > 0 {
+		return opts.Timeout
+	}
+	return c.Timeout
+}
+
+func (c *Client) retryPolicy(opts *RequestOptions) *RetryPolicy {
+	if opts != nil && opts.Retry != nil {
+		return opts.Retry
+	}
+	return c.Retry
+}
+
+func (c *Client) limiter(opts *RequestOptions) *RateLimiter {
+	if opts != nil && opts.Limiter != nil {
+		return opts.Limiter
+	}
+	return c.Limiter
+}
+
+func (c *Client) auth(opts *RequestOptions) Authenticator {
+	if opts != nil && opts.Auth != nil {
+		return opts.Auth
+	}
+	return c.Auth
+}
+
+func (c *Client) signer(opts *RequestOptions) *HMACSigner {
+	if opts != nil && opts.Sign != nil {
+		return opts.Sign
+	}
+	return c.Sign
+}
+
+func (c *Client) resolver() *Resolver {
+	if c.Resolver != nil {
+		return c.Resolver
+	}
+	return DefaultResolver
+}
+
+func (c *Client) url(url string) string {
+	if c.BaseURL == "" || strings.Contains(url, "://") {
+		return url
+	}
+	return strings.TrimSuffix(c.BaseURL, "/") + "/" + strings.TrimPrefix(url, "/")
+}
+
+// withHeader returns a copy of opts with the header set.
+func withHeader(opts *RequestOptions, key, value string) *RequestOptions {
+	opts2 := RequestOptions{}
+	if opts != nil {
+		opts2 = *opts
+	}
+	opts2.Headers = map[string]string{}
+	if opts != nil {
+		for k, v := range opts.Headers {
+			opts2.Headers[k] = v
+		}
+	}
+	opts2.Headers[key] = value
+	return &opts2
+}
+
+func (c *Client) newRequest(ctx context.Context, url, method string, body io.Reader, opts *RequestOptions) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(withDebug(ctx, opts), method, c.url(url), body)
+	if err != nil {
+		return nil, C(ctx).Errorf("NewRequest: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	if opts != nil {
+		for k, v := range opts.Headers {
+			req.Header.Set(k, v)
+		}
+	}
+	if req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" {
+		// decoded in roundTrip
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+	return req, nil
+}
+
+// send performs the request and checks the response for errors. URLs with a scheme registered in
+// the Resolver, such as ipfs://, are tried against each gateway in turn. The caller must close the
+// response body if err is nil. If the server responded with an error status, the response is returned
+// along with the error, with its body read into memory.
+func (c *Client) send(ctx context.Context, url, method string, body io.Reader, opts *RequestOptions) (*http.Response, error) {
+	url = c.url(url)
+	urls := c.resolver().Resolve(url)
+	if body != nil {
+		// can't replay the body to another gateway
+		urls = urls[:1]
+	}
+	var digest []byte
+	var err error
+	if opts != nil && opts.VerifyCID {
+		// a CID that can't be verified won't be verifiable from any gateway
+		digest, err = cidDigest(url)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var resp *http.Response
+	for i, u := range urls {
+		resp, err = c.sendURL(ctx, u, method, body, opts)
+		if err == nil && digest != nil {
+			if err = verifyCID(url, digest, resp); err != nil {
+				resp = nil
+			}
+		}
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(urls)-1 {
+			L(ctx).Info().Printf("%v %v failed, trying next gateway: %v", method, u, err)
+		}
+	}
+	return resp, err
+}
+
+// sendURL performs the request to a single URL, retrying according to the retry policy.
+func (c *Client) sendURL(ctx context.Context, url, method string, body io.Reader, opts *RequestOptions) (*http.Response, error) {
+	if opts != nil && opts.CompressBody && body != nil && replayable(body) {
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return nil, C(ctx).Errorf("couldn't read request body: %w", err)
+		}
+		if len(b) >= 1024 {
+			b, err = compressBody(b)
+			if err != nil {
+				return nil, err
+			}
+			opts = withHeader(opts, "Content-Encoding", "gzip")
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := c.newRequest(ctx, url, method, body, opts)
+	if err != nil {
+		return nil, err
+	}
+	policy := c.retryPolicy(opts)
+	if policy.attempts() <= 1 || !policy.allows(req) || !replayable(body) {
+		return c.attempt(req, opts)
+	}
+	// buffer the body so it can be replayed
+	var bodyBytes []byte
+	if body != nil {
+		bodyBytes, err = io.ReadAll(body)
+		if err != nil {
+			return nil, C(ctx).Errorf("couldn't read request body: %w", err)
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		if bodyBytes != nil {
+			req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+			req.ContentLength = int64(len(bodyBytes))
+		}
+		resp, err := c.attempt(req, opts)
+		if err == nil {
+			return resp, nil
+		}
+		if attempt >= policy.attempts() || ctx.Err() != nil || !policy.shouldRetry(resp, err) {
+			if attempt > 1 {
+				L(ctx).Error().Printf("%v %v failed after %v attempts: %v", method, req.URL, attempt, err)
+			}
+			return resp, err
+		}
+		wait, ok := policy.backoff(attempt, resp)
+		if !ok {
+			L(ctx).Error().Printf("%v %v failed and the server asked to wait %v before retrying, longer than MaxBackoff: %v", method, req.URL, wait, err)
+			return resp, err
+		}
+		L(ctx).Info().Printf("%v %v attempt %v of %v failed, retrying in %v: %v", method, req.URL, attempt, policy.attempts(), wait, err)
+		if err2 := sleepCtx(ctx, wait); err2 != nil {
+			return nil, fmt.Errorf("%w, waiting to retry after: %v", requestError(err2), err)
+		}
+	}
+}
+
+// attempt makes a single request. If the server responded with an error, the response is returned
+// along with the error so the status, headers and body, which has been read into memory, can be inspected.
+//
+// If the request gets a 401 and the Authenticator caches credentials, it's tried once more with fresh ones.
+func (c *Client) attempt(req *http.Request, opts *RequestOptions) (*http.Response, error) {
+	auth := c.auth(opts)
+	resp, err := c.attemptAuth(req, opts, auth)
+	inv, ok := auth.(Invalidator)
+	if !ok || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			// can't send the body again
+			return resp, err
+		}
+		body, err2 := req.GetBody()
+		if err2 != nil {
+			return resp, err
+		}
+		req.Body = body
+	}
+	inv.Invalidate()
+	return c.attemptAuth(req, opts, auth)
+}
+
+func (c *Client) attemptAuth(req *http.Request, opts *RequestOptions, auth Authenticator) (*http.Response, error) {
+	if auth != nil {
+		if err := auth.Authenticate(req.Context(), req); err != nil {
+			return nil, err
+		}
+	}
+	if signer := c.signer(opts); signer != nil {
+		if err := signer.Sign(req); err != nil {
+			return nil, err
+		}
+	}
+	release := func() {}
+	limiter := c.limiter(opts)
+	if limiter != nil {
+		var err error
+		release, err = limiter.Wait(req.Context(), req.URL.Host)
+		if err != nil {
+			return nil, requestError(err)
+		}
+	}
+	if c.Breaker != nil {
+		if err := c.Breaker.allow(req.URL.Host); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	resp, err := c.roundTrip(req, opts, release)
+	if c.Breaker != nil {
+		failed := (resp == nil && err != nil) || (resp != nil && resp.StatusCode >= 500)
+		c.Breaker.done(req.URL.Host, failed, errors.Is(err, ErrCanceled))
+	}
+	if limiter != nil && resp != nil {
+		limiter.Observe(req.URL.Host, resp.Header)
+	}
+	return resp, err
+}
+
+// roundTrip sends the request, release is called once the response body is closed.
+func (c *Client) roundTrip(req *http.Request, opts *RequestOptions, release func()) (*http.Response, error) {
+	cancel := release
+	if timeout := c.timeout(opts); timeout > 0 {
+		ctx, cancelCtx := context.WithTimeout(req.Context(), timeout)
+		req = req.WithContext(ctx)
+		cancel = func() {
+			cancelCtx()
+			release()
+		}
+	}
+	resp, err := debugClient(req.Context(), c.httpClient()).Do(req)
+	if err != nil {
+		cancel()
+		return nil, requestError(err)
+	}
+	if enc := resp.Header.Get("Content-Encoding"); (enc == "gzip" || enc == "deflate") && !resp.Uncompressed && hasBody(req, resp) {
+		body, err := decodeContent(enc, resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			cancel()
+			return nil, fmt.Errorf("couldn't decode response: %w", err)
+		}
+		resp.Body = body
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// keep the body for the caller, CheckError reads it for the error
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		cancel()
+		if err != nil {
+			return nil, requestError(err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(b))
+		err = CheckError(resp)
+		resp.Body = io.NopCloser(bytes.NewReader(b))
+		return resp, requestError(err)
+	}
+	// the timeout covers reading the body too, so only cancel once the body is closed
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// hasBody returns whether resp can have a body, which HEAD, 204 and 304 responses don't even when
+// they have the same headers as one that does.
+func hasBody(req *http.Request, resp *http.Response) bool {
+	return req.Method != http.MethodHead && resp.StatusCode != http.StatusNoContent &&
+		resp.StatusCode != http.StatusNotModified && resp.ContentLength != 0
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = requestError(err)
+	}
+	return n, err
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// requestError wraps err with ErrTimeout or ErrCanceled if that's what caused it.
+func requestError(err error) error {
+	if errors.Is(err, ErrTimeout) || errors.Is(err, ErrCanceled) {
+		return err
+	}
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("%w: %w", ErrCanceled, err)
+	}
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
+
+// Do performs a request with any http method and parses the JSON response into tout if tout is not nil.
+func (c *Client) Do(ctx context.Context, url string, method string, body io.Reader, tout any, opts *RequestOptions) error {
+	resp, err := c.send(ctx, url, method, body, opts)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if tout != nil {
+		err = ParseJSONReader(resp.Body, tout)
+		if err != nil {
+			return C(ctx).Errorf("couldn't parse response: %w", err)
+		}
+		return nil
+	}
+	// read what's left of a small response so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return nil
+}
+
+// DoStream is like Do, but returns a JSONStream to read the response one value at a time.
+// The caller must Close the stream.
+func (c *Client) DoStream(ctx context.Context, url string, method string, body io.Reader, opts *RequestOptions) (*JSONStream, error) {
+	opts2 := withHeader(opts, "Accept", ContentTypeNDJSON+", application/json")
+	if opts != nil && opts.Headers["Accept"] != "" {
+		opts2.Headers["Accept"] = opts.Headers["Accept"]
+	}
+	resp, err := c.send(ctx, url, method, body, opts2)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONStream(ctx, resp.Body), nil
+}
+
+func (c *Client) doJSON(ctx context.Context, url, method string, tin, tout any, opts *RequestOptions) error {
+	var body io.Reader
+	if tin != nil {
+		jsonValue, err := json.Marshal(tin)
+		if err != nil {
+			return C(ctx).Error(err)
+		}
+		body = bytes.NewReader(jsonValue)
+	}
+	return c.Do(ctx, url, method, body, tout, opts)
+}
+
+// GetJSON performs a GET request and parses the response into tout.
+func (c *Client) GetJSON(ctx context.Context, url string, tout any, opts *RequestOptions) error {
+	return c.doJSON(ctx, url, http.MethodGet, nil, tout, opts)
+}
+
+// PostJSON performs a POST request with tin as the body then parses the response into tout. tin and tout can be the same object.
+func (c *Client) PostJSON(ctx context.Context, url string, tin, tout any, opts *RequestOptions) error {
+	return c.doJSON(ctx, url, http.MethodPost, tin, tout, opts)
+}
+
+// PutJSON performs a PUT request with tin as the body then parses the response into tout. tin and tout can be the same object.
+func (c *Client) PutJSON(ctx context.Context, url string, tin, tout any, opts *RequestOptions) error {
+	return c.doJSON(ctx, url, http.MethodPut, tin, tout, opts)
+}
+
+// PatchJSON performs a PATCH request with tin as the body then parses the response into tout. tin and tout can be the same object.
+func (c *Client) PatchJSON(ctx context.Context, url string, tin, tout any, opts *RequestOptions) error {
+	return c.doJSON(ctx, url, http.MethodPatch, tin, tout, opts)
+}
+
+// DeleteJSON performs a DELETE request and parses the response into tout if it's not nil.
+func (c *Client) DeleteJSON(ctx context.Context, url string, tout any, opts *RequestOptions) error {
+	return c.doJSON(ctx, url, http.MethodDelete, nil, tout, opts)
+}
+
+// GetBytes performs a GET request and returns the raw response body.
+func (c *Client) GetBytes(ctx context.Context, url string, opts *RequestOptions) ([]byte, error) {
+	resp, err := c.send(ctx, url, http.MethodGet, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,249 @@
+package gotils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type clientThing struct {
+	Name string `json:"name"`
+}
+
+func TestClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/things" {
+			WriteError(w, 404, ErrNotFound)
+			return
+		}
+		if r.Header.Get("User-Agent") != "gotils-test" {
+			t.Error("expected user agent, got", r.Header.Get("User-Agent"))
+		}
+		if r.Header.Get("X-Key") != "override" {
+			t.Error("expected header from options to win, got", r.Header.Get("X-Key"))
+		}
+		in := &clientThing{}
+		ParseJSON(w, r, in)
+		WriteObject(w, 200, &clientThing{Name: r.Method + " " + in.Name})
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := &Client{
+		BaseURL:   ts.URL + "/v1/",
+		UserAgent: "gotils-test",
+		Headers:   map[string]string{"X-Key": "default"},
+	}
+	opts := &RequestOptions{Headers: map[string]string{"X-Key": "override"}}
+	out := &clientThing{}
+	err := c.PostJSON(ctx, "/things", &clientThing{Name: "a"}, out, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "POST a" {
+		t.Error("unexpected response", out.Name)
+	}
+	err = c.PutJSON(ctx, "things", &clientThing{Name: "b"}, out, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "PUT b" {
+		t.Error("unexpected response", out.Name)
+	}
+
+	err = c.GetJSON(ctx, "/nope", out, opts)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	var he HTTPError
+	if !errors.As(err, &he) || he.Code() != 404 {
+		t.Error("expected 404 HTTPError, got", err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+		WriteObject(w, 200, &clientThing{Name: "slow"})
+	}))
+	defer ts.Close()
+
+	out := &clientThing{}
+	err := GetJSON2(context.Background(), ts.URL, out, &RequestOptions{Timeout: 50 * time.Millisecond})
+	if !errors.Is(err, ErrTimeout) {
+		t.Error("expected ErrTimeout, got", err)
+	}
+	if errors.Is(err, ErrCanceled) {
+		t.Error("timeout should not be ErrCanceled")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err = GetJSON2(ctx, ts.URL, out, nil)
+	if !errors.Is(err, ErrCanceled) {
+		t.Error("expected ErrCanceled, got", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Error("expected context.Canceled to be wrapped, got", err)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		in := &clientThing{}
+		ParseJSON(w, r, in)
+		if n < 3 {
+			w.Header().Set("Retry-After", "0")
+			WriteError(w, 503, errors.New("try again"))
+			return
+		}
+		WriteObject(w, 200, in)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := &Client{Retry: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}}
+	out := &clientThing{}
+	err := c.PutJSON(ctx, ts.URL, &clientThing{Name: "replayed"}, out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Error("expected 3 calls, got", calls)
+	}
+	if out.Name != "replayed" {
+		t.Error("body wasn't replayed, got", out.Name)
+	}
+
+	// POST isn't retried unless asked
+	atomic.StoreInt32(&calls, 0)
+	err = c.PostJSON(ctx, ts.URL, &clientThing{Name: "x"}, out, nil)
+	var he HTTPError
+	if !errors.As(err, &he) || he.Code() != 503 {
+		t.Error("expected 503, got", err)
+	}
+	if calls != 1 {
+		t.Error("expected 1 call, got", calls)
+	}
+	atomic.StoreInt32(&calls, 0)
+	err = c.PostJSON(ctx, ts.URL, &clientThing{Name: "x"}, out, &RequestOptions{Retry: &RetryPolicy{MaxAttempts: 3, RetryNonIdempotent: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Error("expected 3 calls, got", calls)
+	}
+}
+
+func TestGenerics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in := &clientThing{}
+		if r.Method == "POST" {
+			ParseJSON(w, r, in)
+		}
+		w.Header().Set("X-Thing", "yes")
+		if r.URL.Path == "/missing" {
+			WriteError(w, 404, errors.New("no such thing"))
+			return
+		}
+		if r.URL.Path == "/busy" {
+			WriteError(w, 503, errors.New("busy"))
+			return
+		}
+		name := r.Method + " " + in.Name
+		if h := r.Header.Get("X-Name"); h != "" {
+			name += " " + h
+		}
+		WriteObject(w, 201, &clientThing{Name: name})
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	v, err := Get[clientThing](ctx, nil, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "GET " {
+		t.Error("unexpected value", v.Name)
+	}
+	// with a configured client
+	c := &Client{BaseURL: ts.URL, Headers: map[string]string{"X-Name": "configured"}}
+	v2, err := Post[*clientThing, *clientThing](ctx, c, "/things", &clientThing{Name: "a"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2.Name != "POST a configured" {
+		t.Error("unexpected value", v2.Name)
+	}
+	resp, err := DoResponse[clientThing](ctx, nil, ts.URL, "DELETE", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 201 || resp.Header.Get("X-Thing") != "yes" || len(resp.Body) == 0 || resp.Value.Name != "DELETE " {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	// error responses come back along with the error
+	resp, err = DoResponse[clientThing](ctx, c, "/missing", "GET", nil, nil)
+	var he HTTPError
+	if !errors.As(err, &he) || he.Code() != 404 {
+		t.Error("expected a 404 error, got", err)
+	}
+	if resp == nil || resp.StatusCode != 404 || resp.Header.Get("X-Thing") != "yes" ||
+		!strings.Contains(string(resp.Body), "no such thing") || resp.Value.Name != "" {
+		t.Errorf("unexpected error response %+v", resp)
+	}
+	// including after running out of retries
+	opts := &RequestOptions{Retry: &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}}
+	resp, err = DoResponse[clientThing](ctx, c, "/busy", "GET", nil, opts)
+	if err == nil || resp == nil || resp.StatusCode != 503 || !strings.Contains(string(resp.Body), "busy") {
+		t.Errorf("unexpected error response %+v: %v", resp, err)
+	}
+	// no response, no Response
+	resp, err = DoResponse[clientThing](ctx, nil, "http://127.0.0.1:1", "GET", nil, nil)
+	if err == nil || resp != nil {
+		t.Errorf("expected just an error, got %+v: %v", resp, err)
+	}
+}
+
+func TestClientRetryWait(t *testing.T) {
+	var calls int32
+	retryAfter := "3600"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", retryAfter)
+		WriteError(w, 503, errors.New("try again"))
+	}))
+	defer ts.Close()
+	c := &Client{Retry: &RetryPolicy{MaxAttempts: 3}}
+
+	// a Retry-After longer than MaxBackoff isn't waited for
+	start := time.Now()
+	err := c.GetJSON(context.Background(), ts.URL, nil, nil)
+	var he HTTPError
+	if !errors.As(err, &he) || he.Code() != 503 || calls != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf("expected a 503 without retrying, got %v after %v calls", err, calls)
+	}
+
+	// running out of time or being canceled while waiting says so
+	retryAfter = "2"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.GetJSON(ctx, ts.URL, nil, nil); !errors.Is(err, ErrTimeout) {
+		t.Error("expected ErrTimeout, got", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := c.GetJSON(ctx, ts.URL, nil, nil); !errors.Is(err, ErrCanceled) {
+		t.Error("expected ErrCanceled, got", err)
+	}
+}
@@ -0,0 +1,382 @@
+package gotils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Compress is middleware that compresses responses with gzip or deflate, whichever the client
+// prefers in Accept-Encoding, and decompresses gzip or deflate request bodies.
+//
+//	c := &gotils.Compress{}
+//	http.ListenAndServe(":8080", c.Handler(mux))
+//
+// Responses smaller than MinSize aren't compressed, unless they're flushed before getting that big,
+// as with server-sent events.
+type Compress struct {
+	// MinSize is the smallest response to compress. Defaults to 1024 bytes.
+	MinSize int
+	// ContentTypes that are compressed. "text/*" matches all text types. Defaults to JSON, NDJSON,
+	// text, JavaScript, XML and SVG.
+	ContentTypes []string
+	// Level is the compression level, defaults to gzip.DefaultCompression.
+	Level int
+	// MaxDecodedSize is the most a compressed request body can decode to before it's rejected with a
+	// 413. Defaults to MaxDecompressedSize.
+	MaxDecodedSize int64
+}
+
+// MaxDecompressedSize is the most a compressed request body can decode to in ParseJSON and Compress,
+// so a small request can't decompress into gigabytes.
+var MaxDecompressedSize int64 = 10 << 20
+
+func (c *Compress) maxDecodedSize() int64 {
+	if c.MaxDecodedSize <= 0 {
+		return MaxDecompressedSize
+	}
+	return c.MaxDecodedSize
+}
+
+var defaultCompressTypes = []string{"application/json", "application/x-ndjson", "text/*", "application/javascript", "application/xml", "image/svg+xml"}
+
+func (c *Compress) minSize() int {
+	if c.MinSize <= 0 {
+		return 1024
+	}
+	return c.MinSize
+}
+
+func (c *Compress) level() int {
+	if c.Level == 0 {
+		return gzip.DefaultCompression
+	}
+	return c.Level
+}
+
+func (c *Compress) compressible(contentType string) bool {
+	types := c.ContentTypes
+	if len(types) == 0 {
+		types = defaultCompressTypes
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		if t == mt || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding returns "gzip", "deflate" or "" from an Accept-Encoding header.
+func negotiateEncoding(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if name == "*" {
+			name = "gzip"
+		}
+		if name != "gzip" && name != "deflate" || q <= 0 {
+			continue
+		}
+		// prefer gzip when they're equal
+		if q > bestQ || (q == bestQ && name == "gzip") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// Handler returns an http.Handler that compresses responses from h and decompresses request bodies.
+func (c *Compress) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := decompressRequest(r, c.maxDecodedSize()); err != nil {
+			handleErr(w, err)
+			return
+		}
+		cw := c.wrap(w, r)
+		defer cw.Close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// Wrap returns a handler that compresses responses from h and decompresses request bodies.
+// Errors returned by h are compressed too.
+func (c *Compress) Wrap(h ErrorHandlerFunc) ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if err := decompressRequest(r, c.maxDecodedSize()); err != nil {
+			return err
+		}
+		cw := c.wrap(w, r)
+		defer cw.Close()
+		err := h(cw, r)
+		if err != nil {
+			handleErr(cw, err)
+		}
+		return nil
+	}
+}
+
+func (c *Compress) wrap(w http.ResponseWriter, r *http.Request) *compressWriter {
+	return &compressWriter{ResponseWriter: w, c: c, encoding: negotiateEncoding(r.Header.Get("Accept-Encoding")),
+		head: r.Method == http.MethodHead, ifNoneMatch: r.Header.Get("If-None-Match")}
+}
+
+var gzipWriters sync.Pool
+
+// compressWriter buffers the start of the response until it knows whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	c           *Compress
+	encoding    string
+	head        bool
+	ifNoneMatch string
+
+	code    int
+	buf     []byte
+	decided bool
+	cw      io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided || w.code != 0 {
+		return
+	}
+	w.code = code
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		// no body
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if w.decided {
+		if w.cw != nil {
+			return w.cw.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.c.minSize() {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends the headers, compressing if big is true and the response can be compressed, then
+// writes out anything buffered.
+func (w *compressWriter) decide(big bool) error {
+	w.decided = true
+	h := w.Header()
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	// ranges are of the unencoded body, so they're sent as is
+	eligible := h.Get("Content-Encoding") == "" && w.c.compressible(h.Get("Content-Type")) && w.code >= 200 &&
+		w.code != http.StatusNoContent && w.code != http.StatusNotModified && w.code != http.StatusPartialContent &&
+		h.Get("Content-Range") == ""
+	if eligible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if w.code == http.StatusNotModified && w.encoding != "" {
+		// the client has the encoded response, so keep its ETag
+		if etag := encodedETag(h.Get("ETag"), w.encoding); etagMatches(w.ifNoneMatch, etag, false) {
+			h.Set("ETag", etag)
+		}
+	}
+	if eligible && big && w.encoding != "" && !w.head {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		// the encoded body is a different representation, so it gets its own strong ETag
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", encodedETag(etag, w.encoding))
+		}
+		if w.encoding == "gzip" {
+			gz, _ := gzipWriters.Get().(*gzip.Writer)
+			if gz == nil || w.c.level() != gzip.DefaultCompression {
+				var err error
+				gz, err = gzip.NewWriterLevel(w.ResponseWriter, w.c.level())
+				if err != nil {
+					return err
+				}
+			} else {
+				gz.Reset(w.ResponseWriter)
+			}
+			w.cw = gz
+		} else {
+			zw, err := zlib.NewWriterLevel(w.ResponseWriter, w.c.level())
+			if err != nil {
+				return err
+			}
+			w.cw = zw
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.cw != nil {
+		_, err = w.cw.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// Flush sends what's been written so far, compressing it if the content type allows, so streams
+// such as server-sent events keep working.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if err := w.decide(true); err != nil {
+			return
+		}
+	}
+	if f, ok := w.cw.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response.
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		if w.code == 0 && len(w.buf) == 0 {
+			// nothing was written, let net/http send its default response
+			return nil
+		}
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.cw == nil {
+		return nil
+	}
+	err := w.cw.Close()
+	if gz, ok := w.cw.(*gzip.Writer); ok && w.c.level() == gzip.DefaultCompression {
+		gzipWriters.Put(gz)
+	}
+	w.cw = nil
+	return err
+}
+
+// Unwrap lets http.ResponseController get at the underlying ResponseWriter.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decompressRequest replaces a gzip or deflate encoded request body with the decoded one, which
+// returns a 413 HTTPError if it decodes to more than max bytes.
+func decompressRequest(r *http.Request, max int64) error {
+	enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if enc == "" || enc == "identity" || r.Body == nil {
+		return nil
+	}
+	body, err := decodeContent(enc, r.Body)
+	if errors.Is(err, errUnsupportedEncoding) {
+		return NewHTTPError(err.Error(), http.StatusUnsupportedMediaType)
+	}
+	if err != nil {
+		return NewHTTPError(err.Error(), http.StatusBadRequest)
+	}
+	r.Body = readCloser{&maxSizeReader{r: io.LimitReader(body, max+1), max: max, msg: "decompressed request body too large"}, body}
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+// maxSizeReader returns a 413 HTTPError with msg once more than max bytes have been read.
+type maxSizeReader struct {
+	r    io.Reader
+	max  int64
+	msg  string
+	read int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.read += int64(n)
+	if m.read > m.max {
+		return n - int(m.read-m.max), NewHTTPError(m.msg, http.StatusRequestEntityTooLarge)
+	}
+	return n, err
+}
+
+var errUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+
+// decodeContent returns a reader that decodes body, which is encoded with enc. An empty body decodes
+// to an empty body.
+func decodeContent(enc string, body io.ReadCloser) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	var err error
+	switch enc {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(body)
+	case "deflate":
+		r, err = zlib.NewReader(body)
+	default:
+		return nil, fmt.Errorf("%w %q", errUnsupportedEncoding, enc)
+	}
+	if err == io.EOF {
+		return readCloser{bytes.NewReader(nil), body}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v body: %w", enc, err)
+	}
+	return readCloser{r, multiCloser{r, body}}, nil
+}
+
+type multiCloser []io.Closer
+
+func (mc multiCloser) Close() error {
+	var err error
+	for _, c := range mc {
+		if err2 := c.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// compressBody gzips b.
+func compressBody(b []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write(b); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
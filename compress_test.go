@@ -0,0 +1,279 @@
+package gotils
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompress(t *testing.T) {
+	big := strings.Repeat("hello ", 1000)
+	cmp := &Compress{}
+	var received string
+	mux := http.NewServeMux()
+	mux.Handle("/big", ErrorHandler(cmp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method == "POST" {
+			in := map[string]string{}
+			if err := ParseJSON(w, r, &in); err != nil {
+				return err
+			}
+			received = in["text"]
+		}
+		return WriteObject(w, 200, map[string]string{"text": big})
+	})))
+	mux.Handle("/small", ErrorHandler(cmp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		return WriteObject(w, 200, map[string]string{"text": "hi"})
+	})))
+	mux.Handle("/png", cmp.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(big))
+	})))
+	mux.Handle("/stream", ErrorHandler(cmp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		sw := NewStreamWriter(r.Context(), w, 200, nil)
+		sw.Write(map[string]int{"n": 1})
+		sw.Flush()
+		<-r.Context().Done()
+		return nil
+	})))
+	var encoding string
+	mux.Handle("/echo", ErrorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		encoding = r.Header.Get("Content-Encoding")
+		in := map[string]string{}
+		if err := ParseJSON(w, r, &in); err != nil {
+			return err
+		}
+		return WriteObject(w, 200, in)
+	}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	get := func(path, accept string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	for accept, want := range map[string]string{"gzip": "gzip", "deflate;q=1, gzip;q=0.5": "deflate", "br": "", "gzip;q=0": ""} {
+		resp := get("/big", accept)
+		var r io.Reader = resp.Body
+		switch want {
+		case "gzip":
+			r, _ = gzip.NewReader(resp.Body)
+		case "deflate":
+			r, _ = zlib.NewReader(resp.Body)
+		}
+		b, _ := io.ReadAll(r)
+		resp.Body.Close()
+		if resp.Header.Get("Content-Encoding") != want || !strings.Contains(string(b), big) || resp.Header.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("accept %v: unexpected response %v %v", accept, resp.Header, len(b))
+		}
+	}
+	for _, path := range []string{"/small", "/png"} {
+		resp := get(path, "gzip")
+		resp.Body.Close()
+		if resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("%v: expected no compression", path)
+		}
+	}
+
+	// streams are flushed through the compressor
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/stream", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _ := bufio.NewReader(gz).ReadString('\n')
+	if line != `{"n":1}`+"\n" {
+		t.Errorf("unexpected stream %q", line)
+	}
+	cancel()
+	resp.Body.Close()
+
+	// the client asks for compression, decodes it and can compress what it sends
+	c := NewClient(ts.URL)
+	out := map[string]string{}
+	err = c.PostJSON(context.Background(), "/big", map[string]string{"text": big}, &out, &RequestOptions{CompressBody: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["text"] != big || received != big {
+		t.Error("expected the bodies to make it through")
+	}
+	// without the middleware, ParseJSON decodes it
+	out = map[string]string{}
+	err = c.PostJSON(context.Background(), "/echo", map[string]string{"text": big}, &out, &RequestOptions{CompressBody: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["text"] != big || encoding != "gzip" {
+		t.Errorf("expected a gzipped body to be decoded, got encoding %q", encoding)
+	}
+}
+
+func TestClientDecodeNoBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		switch r.URL.Path {
+		case "/head":
+			w.Header().Set("Content-Length", "100")
+		case "/204":
+			w.WriteHeader(204)
+		case "/304":
+			w.WriteHeader(304)
+		case "/empty":
+			w.Header().Set("Content-Length", "0")
+		case "/corrupt":
+			w.Write([]byte("not gzip"))
+		}
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+	c := NewClient(ts.URL)
+
+	if err := c.Do(ctx, "/head", "HEAD", nil, nil, nil); err != nil {
+		t.Error("HEAD:", err)
+	}
+	if err := c.DeleteJSON(ctx, "/204", nil, nil); err != nil {
+		t.Error("204:", err)
+	}
+	if err := c.GetJSON(ctx, "/empty", nil, nil); err != nil {
+		t.Error("empty:", err)
+	}
+	var he HTTPError
+	if err := c.GetJSON(ctx, "/304", nil, nil); !errors.As(err, &he) || he.Code() != 304 {
+		t.Error("304: expected the status, got", err)
+	}
+	// a bad body is the client's problem, not a 400 from the server
+	err := c.GetJSON(ctx, "/corrupt", nil, nil)
+	if err == nil || errors.As(err, &he) {
+		t.Error("corrupt: expected a decode error, got", err)
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	bomb, _ := compressBody([]byte(`{"text":"` + strings.Repeat("a", 1<<20) + `"}`))
+	handler := func(w http.ResponseWriter, r *http.Request) error {
+		in := map[string]string{}
+		if err := ParseJSON(w, r, &in); err != nil {
+			return err
+		}
+		return WriteObject(w, 200, map[string]int{"len": len(in["text"])})
+	}
+	post := func(h http.Handler) int {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(string(bomb)))
+		r.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := post(ErrorHandler(handler)); code != 200 {
+		t.Errorf("expected 200 under the default limit, got %v", code)
+	}
+	if code := post((&Compress{MaxDecodedSize: 1 << 10}).Handler(ErrorHandler(handler))); code != 413 {
+		t.Errorf("expected 413 from the middleware limit, got %v", code)
+	}
+	defer func(max int64) { MaxDecompressedSize = max }(MaxDecompressedSize)
+	MaxDecompressedSize = 1 << 10
+	if code := post(ErrorHandler(handler)); code != 413 {
+		t.Errorf("expected 413 from ParseJSON, got %v", code)
+	}
+}
+
+func TestCompressETag(t *testing.T) {
+	big := strings.Repeat("hello ", 1000)
+	thing := &etagThing{Name: big}
+	h := (&Compress{}).Handler(ErrorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		etag, err := ETagOf(thing)
+		if err != nil {
+			return err
+		}
+		if r.Method == http.MethodPut {
+			if err := CheckIfMatch(r, etag); err != nil {
+				return err
+			}
+			return WriteMessage(w, 200, "updated")
+		}
+		return WriteObjectETag(w, r, 200, thing)
+	}))
+	do := func(method, accept, header, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/", nil)
+		r.Header.Set("Accept-Encoding", accept)
+		if etag != "" {
+			r.Header.Set(header, etag)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	plain := do("GET", "", "", "").Header().Get("ETag")
+	gzipped := strings.TrimSuffix(plain, `"`) + `-gzip"`
+	w := do("GET", "gzip", "", "")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("ETag") != gzipped {
+		t.Errorf("expected an encoding specific ETag for the gzipped response, got %v", w.Header())
+	}
+	if w = do("GET", "gzip", "If-None-Match", gzipped); w.Code != http.StatusNotModified || w.Header().Get("ETag") != gzipped {
+		t.Errorf("expected 304 with the gzipped ETag, got %v %v", w.Code, w.Header())
+	}
+	if w = do("GET", "gzip", "If-None-Match", plain); w.Code != http.StatusNotModified || w.Header().Get("ETag") != plain {
+		t.Errorf("expected 304 with the plain ETag, got %v %v", w.Code, w.Header())
+	}
+
+	// If-Match is a strong comparison, and the ETag from the compressed response still works
+	for _, etag := range []string{plain, gzipped, strings.TrimSuffix(plain, `"`) + `-deflate"`} {
+		if w = do("PUT", "gzip", "If-Match", etag); w.Code != 200 {
+			t.Errorf("If-Match %v: expected 200, got %v", etag, w.Code)
+		}
+	}
+	for _, etag := range []string{"W/" + gzipped, `"other-gzip"`} {
+		if w = do("PUT", "gzip", "If-Match", etag); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("If-Match %v: expected 412, got %v", etag, w.Code)
+		}
+	}
+}
+
+func TestCompressRange(t *testing.T) {
+	big := strings.Repeat("hello ", 1000)
+	h := (&Compress{MinSize: 10}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		http.ServeContent(w, r, "hello.txt", time.Time{}, strings.NewReader(big))
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=6-1999")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusPartialContent || w.Header().Get("Content-Encoding") != "" || w.Body.String() != big[6:2000] {
+		t.Errorf("expected the range uncompressed, got %v %v %v bytes", w.Code, w.Header(), w.Body.Len())
+	}
+	if cr := w.Header().Get("Content-Range"); cr != "bytes 6-1999/6000" {
+		t.Errorf("unexpected Content-Range %v", cr)
+	}
+
+	// the whole thing is still compressed
+	r.Header.Del("Range")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != 200 || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected a gzipped response, got %v %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,175 @@
+package gotils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS is middleware that adds Cross-Origin Resource Sharing headers and answers preflight requests.
+// The headers are set before the handler runs, so errors written with WriteError have them too.
+//
+//	cors := &gotils.CORS{AllowedOrigins: []string{"https://app.example.com", "https://*.example.dev"}, AllowCredentials: true}
+//	http.ListenAndServe(":8080", cors.Handler(mux))
+type CORS struct {
+	// AllowedOrigins can be exact origins like "https://example.com", a wildcard subdomain like
+	// "https://*.example.com", or "*" for any origin. "*" can't be used with AllowCredentials, since it
+	// would let any site make authenticated requests. The "null" origin of sandboxed pages and local
+	// files is never echoed back.
+	AllowedOrigins []string
+	// AllowOriginFunc, if set, is checked for origins that aren't in AllowedOrigins.
+	AllowOriginFunc func(origin string) bool
+	// AllowedMethods defaults to GET, HEAD, POST, PUT, PATCH and DELETE.
+	AllowedMethods []string
+	// AllowedHeaders the client can send. Defaults to allowing whatever headers the preflight asks for.
+	AllowedHeaders []string
+	// ExposedHeaders are response headers the browser lets scripts read.
+	ExposedHeaders []string
+	// AllowCredentials lets the browser send cookies and auth headers.
+	AllowCredentials bool
+	// MaxAge is how long browsers can cache preflight responses.
+	MaxAge time.Duration
+}
+
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+func (c *CORS) allowOrigin(origin string) bool {
+	if origin == "null" {
+		// only allowed as part of "*", which doesn't echo it
+		return contains(c.AllowedOrigins, "*") && !c.AllowCredentials
+	}
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(o, "*"); ok {
+			// "https://*.example.com" matches "https://api.example.com" but not "https://example.com"
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return c.AllowOriginFunc != nil && c.AllowOriginFunc(origin)
+}
+
+func (c *CORS) methods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return defaultCORSMethods
+	}
+	return c.AllowedMethods
+}
+
+func (c *CORS) allowMethod(method string) bool {
+	for _, m := range c.methods() {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) allowHeaders(requested string) bool {
+	if len(c.AllowedHeaders) == 0 {
+		return true
+	}
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		ok := false
+		for _, a := range c.AllowedHeaders {
+			if a == "*" || strings.EqualFold(a, h) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// apply sets the CORS headers on w and returns whether r was a preflight request, which has been answered.
+func (c *CORS) apply(w http.ResponseWriter, r *http.Request) bool {
+	h := w.Header()
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	h.Add("Vary", "Origin")
+	if preflight {
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+	}
+	if origin == "" || !c.allowOrigin(origin) {
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return preflight
+	}
+	if contains(c.AllowedOrigins, "*") {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		if len(c.ExposedHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+		}
+		return false
+	}
+
+	reqHeaders := r.Header.Get("Access-Control-Request-Headers")
+	if !c.allowMethod(r.Header.Get("Access-Control-Request-Method")) || !c.allowHeaders(reqHeaders) {
+		h.Del("Access-Control-Allow-Origin")
+		h.Del("Access-Control-Allow-Credentials")
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(c.methods(), ", "))
+	if len(c.AllowedHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+	} else if reqHeaders != "" {
+		h.Set("Access-Control-Allow-Headers", reqHeaders)
+	}
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// check panics if c allows credentials from any origin.
+func (c *CORS) check() {
+	if c.AllowCredentials && contains(c.AllowedOrigins, "*") {
+		panic(`gotils: CORS AllowedOrigins "*" can't be used with AllowCredentials`)
+	}
+}
+
+// Handler returns an http.Handler that adds CORS headers and answers preflight requests without calling h.
+// It panics if AllowedOrigins has "*" and AllowCredentials is set.
+func (c *CORS) Handler(h http.Handler) http.Handler {
+	c.check()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.apply(w, r) {
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Wrap returns a handler that adds CORS headers and answers preflight requests without calling h.
+// It panics if AllowedOrigins has "*" and AllowCredentials is set.
+func (c *CORS) Wrap(h ErrorHandlerFunc) ErrorHandlerFunc {
+	c.check()
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if c.apply(w, r) {
+			return nil
+		}
+		return h(w, r)
+	}
+}
@@ -0,0 +1,103 @@
+package gotils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	cors := &CORS{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.dev"},
+		AllowOriginFunc:  func(o string) bool { return strings.HasSuffix(o, ".test") },
+		AllowCredentials: true,
+		ExposedHeaders:   []string{"X-Request-Id"},
+		MaxAge:           time.Hour,
+	}
+	called := 0
+	h := ErrorHandler(cors.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		called++
+		return ErrNotFound
+	}))
+
+	do := func(method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/things", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	// errors still get the headers
+	w := do("GET", "https://app.example.com", nil)
+	if w.Code != 404 || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "true" || w.Header().Get("Access-Control-Expose-Headers") != "X-Request-Id" ||
+		w.Header().Get("Vary") != "Origin" {
+		t.Errorf("unexpected response %v %v", w.Code, w.Header())
+	}
+
+	for origin, allowed := range map[string]bool{
+		"https://api.example.dev": true,
+		"https://example.dev":     false,
+		"https://evil.com":        false,
+		"http://localhost.test":   true,
+	} {
+		w = do("GET", origin, nil)
+		if got := w.Header().Get("Access-Control-Allow-Origin") == origin; got != allowed {
+			t.Errorf("origin %v: expected allowed %v", origin, allowed)
+		}
+	}
+
+	called = 0
+	w = do("OPTIONS", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "Content-Type, Authorization",
+	})
+	if called != 0 || w.Code != 204 || w.Header().Get("Access-Control-Allow-Headers") != "Content-Type, Authorization" ||
+		!strings.Contains(w.Header().Get("Access-Control-Allow-Methods"), "PUT") || w.Header().Get("Access-Control-Max-Age") != "3600" {
+		t.Errorf("unexpected preflight %v %v", w.Code, w.Header())
+	}
+	w = do("OPTIONS", "https://app.example.com", map[string]string{"Access-Control-Request-Method": "TRACE"})
+	if called != 0 || w.Code != 403 || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected preflight for a disallowed method to fail, got %v %v", w.Code, w.Header())
+	}
+
+	// no origin, not a CORS request
+	w = do("GET", "", nil)
+	if called != 1 || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("expected no CORS headers without an Origin")
+	}
+
+	all := &CORS{AllowedOrigins: []string{"*"}}
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Origin", "https://whatever.com")
+	all.Handler(http.NotFoundHandler()).ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("expected *, got", w.Header())
+	}
+
+	// the null origin is never echoed, even if AllowOriginFunc says yes
+	lax := &CORS{AllowOriginFunc: func(string) bool { return true }, AllowCredentials: true}
+	w = httptest.NewRecorder()
+	r.Header.Set("Origin", "null")
+	lax.Handler(http.NotFoundHandler()).ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("expected the null origin to be refused, got", w.Header())
+	}
+
+	// any origin with credentials is refused when it's set up
+	defer func() {
+		if recover() == nil {
+			t.Error(`expected a panic for "*" with AllowCredentials`)
+		}
+	}()
+	(&CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true}).Handler(http.NotFoundHandler())
+}
@@ -0,0 +1,482 @@
+package gotils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DebugEnv is the environment variable that turns on curl dumps for every request when set to a true
+// value such as "1" or "true".
+const DebugEnv = "GOTILS_HTTP_DEBUG"
+
+const debugContextKey = contextKey("http_debug")
+
+// curlMaxBody is how much of each body a curl dump logs.
+const curlMaxBody = 1 << 20
+
+// defaultRedactFields are the body fields left out of logs by default.
+var defaultRedactFields = []string{"password", "token", "access_token", "refresh_token", "secret", "client_secret", "api_key"}
+
+// LoggingTransport is an http.RoundTripper that logs each request's method, URL, status, duration and
+// the start of the request and response bodies through L(ctx) at debug level. Sensitive headers and
+// JSON fields are redacted, and gzip or deflate responses are logged decoded. Each request is logged
+// once its response body has been read or closed, so streams aren't held up.
+//
+//	c := &gotils.Client{HTTPClient: &http.Client{Transport: &gotils.LoggingTransport{}}}
+//
+// With Curl set, or RequestOptions.Debug or the GOTILS_HTTP_DEBUG environment variable, it also logs
+// the request as a curl command and the response, up to 1 MB of each body, so the call can be reproduced. The Client adds
+// a LoggingTransport for those requests if it doesn't already have one.
+type LoggingTransport struct {
+	// Next is the transport that sends the request, defaults to http.DefaultTransport.
+	Next http.RoundTripper
+	// MaxBody is how many bytes of each body to log. Defaults to 1024, -1 logs no bodies.
+	MaxBody int
+	// RedactHeaders are replaced with "REDACTED". Defaults to Authorization, Cookie, Set-Cookie and X-Api-Key.
+	RedactHeaders []string
+	// RedactFields are JSON body fields, at any depth, and form fields that are replaced with "REDACTED". Matching
+	// ignores case. Defaults to password, token, access_token, refresh_token, secret, client_secret and api_key.
+	RedactFields []string
+	// Curl logs a curl command for every request along with the full response.
+	Curl bool
+}
+
+func (t *LoggingTransport) next() http.RoundTripper {
+	if t.Next != nil {
+		return t.Next
+	}
+	return http.DefaultTransport
+}
+
+func (t *LoggingTransport) maxBody() int {
+	if t.MaxBody == 0 {
+		return 1024
+	}
+	return t.MaxBody
+}
+
+// RoundTrip sends the request with Next and logs it.
+func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	curl := t.Curl || debugEnabled(ctx)
+	limit := t.maxBody()
+	if curl && limit < curlMaxBody {
+		limit = curlMaxBody
+	}
+
+	// compressed bodies aren't worth logging
+	var reqBody *captureReader
+	if req.Body != nil && req.Body != http.NoBody && limit != -1 && req.Header.Get("Content-Encoding") == "" {
+		if req.GetBody != nil {
+			// a copy we can read without affecting what's sent
+			if rc, err := req.GetBody(); err == nil {
+				reqBody = &captureReader{limit: limit}
+				io.Copy(io.Discard, io.TeeReader(rc, reqBody))
+				rc.Close()
+			}
+		} else {
+			// a streaming body, capture it as it's sent
+			reqBody = &captureReader{r: req.Body, limit: limit}
+			req2 := req.Clone(ctx)
+			req2.Body = reqBody
+			req = req2
+		}
+	}
+
+	start := time.Now()
+	resp, err := t.next().RoundTrip(req)
+	took := time.Since(start)
+	if err != nil {
+		if curl {
+			L(ctx).Debug().Printf("HTTP curl:\n%v", t.curl(req, reqBody, limit))
+		}
+		L(ctx).Debug().Printf("HTTP %v %v failed after %v: %v", req.Method, req.URL, took, err)
+		return nil, err
+	}
+
+	// compressed responses are logged decoded, if they're gzip or deflate
+	enc := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if limit == -1 || (enc != "" && enc != "gzip" && enc != "x-gzip" && enc != "deflate") {
+		t.log(ctx, req, reqBody, resp, took, nil, curl, limit)
+		return resp, nil
+	}
+	// log once the body has been read, so streams aren't held up and only limit bytes are kept
+	body := &loggedBody{r: resp.Body, capture: captureReader{limit: limit}}
+	if enc != "" {
+		body.capture.limit += decodeSlack
+	}
+	body.done = func(b []byte) {
+		if enc != "" {
+			b = decodePrefix(enc, b, limit)
+		}
+		t.log(ctx, req, reqBody, resp, took, b, curl, limit)
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+func (t *LoggingTransport) log(ctx context.Context, req *http.Request, reqBody *captureReader, resp *http.Response, took time.Duration, respBody []byte, curl bool, limit int) {
+	if curl {
+		L(ctx).Debug().Printf("HTTP curl:\n%v\n%v %v\n%v\n%v", t.curl(req, reqBody, limit), resp.Proto, resp.Status,
+			formatHeaders(redactHeaders(resp.Header, t.RedactHeaders)), t.truncate(respBody, limit, resp.Header.Get("Content-Type")))
+		return
+	}
+	msg := fmt.Sprintf("HTTP %v %v -> %v in %v", req.Method, req.URL, resp.StatusCode, took)
+	if reqBody != nil && len(reqBody.buf) > 0 {
+		msg += fmt.Sprintf("\nrequest: %s", t.truncate(reqBody.buf, limit, req.Header.Get("Content-Type")))
+	}
+	if len(respBody) > 0 {
+		msg += fmt.Sprintf("\nresponse: %s", t.truncate(respBody, limit, resp.Header.Get("Content-Type")))
+	}
+	L(ctx).Debug().Printf("%v", msg)
+}
+
+func (t *LoggingTransport) truncate(b []byte, limit int, contentType string) string {
+	form := isForm(contentType)
+	if limit >= 0 && len(b) > limit {
+		if form {
+			return string(redactForm(b[:limit], t.redactFieldNames())) + "...(truncated)"
+		}
+		// a cut off document can't be parsed, so scan it for fields to redact
+		return string(redactPrefix(b[:limit], t.redactFieldNames())) + "...(truncated)"
+	}
+	if form {
+		return string(redactForm(b, t.redactFieldNames()))
+	}
+	return string(t.redactBody(b))
+}
+
+func (t *LoggingTransport) redactFieldNames() []string {
+	if t.RedactFields == nil {
+		return defaultRedactFields
+	}
+	return t.RedactFields
+}
+
+// redactBody replaces RedactFields in a JSON body. Other bodies are returned as is.
+func (t *LoggingTransport) redactBody(b []byte) []byte {
+	if len(b) == 0 || (b[0] != '{' && b[0] != '[') {
+		return b
+	}
+	v, err := decodePatchJSON(b)
+	if err != nil {
+		return b
+	}
+	if !redactFields(v, t.redactFieldNames()) {
+		return b
+	}
+	b2, err := json.Marshal(v)
+	if err != nil {
+		return b
+	}
+	return b2
+}
+
+func isForm(contentType string) bool {
+	mt, _, _ := strings.Cut(contentType, ";")
+	return strings.EqualFold(strings.TrimSpace(mt), "application/x-www-form-urlencoded")
+}
+
+// redactForm replaces the values of fields in a form encoded body. It works pair by pair, keeping the
+// order, so a body cut off part way through is still redacted.
+func redactForm(b []byte, fields []string) []byte {
+	pairs := strings.Split(string(b), "&")
+	for i, p := range pairs {
+		k, _, _ := strings.Cut(p, "=")
+		if name, err := url.QueryUnescape(k); err == nil && containsFold(fields, name) {
+			pairs[i] = k + "=REDACTED"
+		}
+	}
+	return []byte(strings.Join(pairs, "&"))
+}
+
+// redactFields redacts fields in v in place and returns whether it changed anything.
+func redactFields(v any, fields []string) bool {
+	changed := false
+	switch vt := v.(type) {
+	case map[string]any:
+		for k, v2 := range vt {
+			if containsFold(fields, k) {
+				vt[k] = "REDACTED"
+				changed = true
+			} else if redactFields(v2, fields) {
+				changed = true
+			}
+		}
+	case []any:
+		for _, v2 := range vt {
+			if redactFields(v2, fields) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// redactPrefix redacts fields in b, the start of a JSON document that's been cut off. It looks for
+// object keys followed by a colon and replaces their values, even ones that are cut off themselves.
+func redactPrefix(b []byte, fields []string) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); {
+		if b[i] != '"' {
+			out = append(out, b[i])
+			i++
+			continue
+		}
+		end := jsonStringEnd(b, i)
+		out = append(out, b[i:end]...)
+		key := b[i:end]
+		i = end
+		colon := skipJSONSpace(b, i)
+		if colon >= len(b) || b[colon] != ':' {
+			continue
+		}
+		var k string
+		if json.Unmarshal(key, &k) != nil || !containsFold(fields, k) {
+			continue
+		}
+		value := skipJSONSpace(b, colon+1)
+		out = append(out, b[i:value]...)
+		out = append(out, `"REDACTED"`...)
+		i = jsonValueEnd(b, value)
+	}
+	return out
+}
+
+func containsFold(ss []string, s string) bool {
+	for _, s2 := range ss {
+		if strings.EqualFold(s2, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func skipJSONSpace(b []byte, i int) int {
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == '\r' || b[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// jsonStringEnd returns the index after the string starting at b[i], or len(b) if it's cut off.
+func jsonStringEnd(b []byte, i int) int {
+	for j := i + 1; j < len(b); j++ {
+		switch b[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1
+		}
+	}
+	return len(b)
+}
+
+// jsonValueEnd returns the index after the value starting at b[i], or len(b) if it's cut off.
+func jsonValueEnd(b []byte, i int) int {
+	if i >= len(b) {
+		return i
+	}
+	switch b[i] {
+	case '"':
+		return jsonStringEnd(b, i)
+	case '{', '[':
+		depth := 0
+		for j := i; j < len(b); j++ {
+			switch b[j] {
+			case '"':
+				j = jsonStringEnd(b, j) - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return j + 1
+				}
+			}
+		}
+		return len(b)
+	}
+	for j := i; j < len(b); j++ {
+		switch b[j] {
+		case ',', '}', ']', ' ', '\t', '\r', '\n':
+			return j
+		}
+	}
+	return len(b)
+}
+
+// curl returns a curl command equivalent to req.
+func (t *LoggingTransport) curl(req *http.Request, body *captureReader, limit int) string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "curl -X %v %v", req.Method, shellQuote(req.URL.String()))
+	if req.Header.Get("Accept-Encoding") != "" {
+		// so curl decodes the response too
+		sb.WriteString(" --compressed")
+	}
+	h := redactHeaders(req.Header, t.RedactHeaders)
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(sb, " \\\n  -H %v", shellQuote(k+": "+v))
+		}
+	}
+	if body != nil && len(body.buf) > 0 {
+		fmt.Fprintf(sb, " \\\n  --data-raw %v", shellQuote(t.truncate(body.buf, limit, req.Header.Get("Content-Type"))))
+	}
+	return sb.String()
+}
+
+func formatHeaders(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sb := &strings.Builder{}
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(sb, "%v: %v\n", k, v)
+		}
+	}
+	return sb.String()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// decodeSlack is how many more compressed bytes than the log limit are kept, enough for the headers
+// and block overhead of data that doesn't compress.
+const decodeSlack = 1024
+
+// decodePrefix decodes up to limit+1 bytes from b, the start of a gzip or deflate body, which is
+// all a log needs.
+func decodePrefix(enc string, b []byte, limit int) []byte {
+	r, err := decodeContent(enc, io.NopCloser(bytes.NewReader(b)))
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	// b is usually cut off, so the error at the end of it is expected
+	out, _ := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	return out
+}
+
+// captureReader keeps a copy of up to limit bytes read through it.
+type captureReader struct {
+	r     io.ReadCloser
+	limit int
+	buf   []byte
+}
+
+func (c *captureReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.Write(p[:n])
+	return n, err
+}
+
+func (c *captureReader) Write(p []byte) (int, error) {
+	// one extra byte so we know it was truncated
+	room := c.limit + 1 - len(c.buf)
+	if room < 0 {
+		room = 0
+	}
+	keep := p
+	if len(keep) > room {
+		keep = keep[:room]
+	}
+	c.buf = append(c.buf, keep...)
+	return len(p), nil
+}
+
+func (c *captureReader) Close() error {
+	return c.r.Close()
+}
+
+// loggedBody keeps the start of a response body as it's read and calls done with it at EOF or Close.
+type loggedBody struct {
+	r       io.ReadCloser
+	mu      sync.Mutex
+	capture captureReader
+	once    sync.Once
+	done    func(b []byte)
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.mu.Lock()
+	b.capture.Write(p[:n])
+	b.mu.Unlock()
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	err := b.r.Close()
+	b.finish()
+	return err
+}
+
+func (b *loggedBody) finish() {
+	b.once.Do(func() {
+		b.mu.Lock()
+		buf := b.capture.buf
+		b.mu.Unlock()
+		b.done(buf)
+	})
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// withDebug marks ctx so the request is dumped as curl, if opts.Debug or DebugEnv is set.
+func withDebug(ctx context.Context, opts *RequestOptions) context.Context {
+	if (opts != nil && opts.Debug) || envDebug() {
+		return context.WithValue(ctx, debugContextKey, true)
+	}
+	return ctx
+}
+
+func debugEnabled(ctx context.Context) bool {
+	b, _ := ctx.Value(debugContextKey).(bool)
+	return b
+}
+
+func envDebug() bool {
+	b, _ := strconv.ParseBool(os.Getenv(DebugEnv))
+	return b
+}
+
+// debugClient returns hc with a LoggingTransport added if the request should be dumped and hc doesn't
+// already log.
+func debugClient(ctx context.Context, hc *http.Client) *http.Client {
+	if !debugEnabled(ctx) {
+		return hc
+	}
+	if _, ok := hc.Transport.(*LoggingTransport); ok {
+		return hc
+	}
+	hc2 := *hc
+	hc2.Transport = &LoggingTransport{Next: hc.Transport}
+	return &hc2
+}
@@ -0,0 +1,199 @@
+package gotils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type captureLog struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (c *captureLog) Logf(ctx context.Context, severity, format string, a ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logs = append(c.logs, severity+": "+fmt.Sprintf(format, a...))
+}
+
+func (c *captureLog) Log(ctx context.Context, severity string, a ...interface{}) {
+	c.Logf(ctx, severity, "%v", fmt.Sprint(a...))
+}
+
+func (c *captureLog) all() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := strings.Join(c.logs, "\n")
+	c.logs = nil
+	return s
+}
+
+func TestLoggingTransport(t *testing.T) {
+	logs := &captureLog{}
+	SetLoggable(logs)
+	defer SetLoggable(nil)
+
+	m := NewMockServer(t)
+	m.Expect("POST", "/login").Respond(200, map[string]any{"access_token": "abc123", "user": strings.Repeat("x", 100)}).Times(2)
+	ctx := context.Background()
+	c := NewClient(m.URL)
+	in := map[string]any{"username": "bob", "password": "hunter2"}
+	opts := &RequestOptions{Headers: map[string]string{"Authorization": "Bearer secret"}}
+
+	// no logging transport, nothing logged
+	c.PostJSON(ctx, "/login", in, nil, nil)
+	if s := logs.all(); s != "" {
+		t.Error("expected no logs, got", s)
+	}
+
+	c.HTTPClient = &http.Client{Transport: &LoggingTransport{MaxBody: 50}}
+	if err := c.PostJSON(ctx, "/login", in, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	s := logs.all()
+	if !strings.Contains(s, "debug: HTTP POST "+m.URL+"/login -> 200") || !strings.Contains(s, `"password":"REDACTED"`) ||
+		!strings.Contains(s, "...(truncated)") {
+		t.Error("unexpected log", s)
+	}
+	if strings.Contains(s, "hunter2") || strings.Contains(s, "secret") || strings.Contains(s, "abc123") {
+		t.Error("expected secrets to be redacted", s)
+	}
+
+	// curl dump per request, without a logging transport
+	m.Expect("GET", "/me").Respond(200, map[string]any{"name": "bob"})
+	c.HTTPClient = nil
+	opts.Debug = true
+	if err := c.GetJSON(ctx, "/me", nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	s = logs.all()
+	if !strings.Contains(s, "curl -X GET '"+m.URL+"/me'") || !strings.Contains(s, "-H 'Authorization: REDACTED'") ||
+		!strings.Contains(s, "200 OK") || !strings.Contains(s, `{"name":"bob"}`) {
+		t.Error("unexpected curl dump", s)
+	}
+}
+
+func TestLoggingTransportLargeBody(t *testing.T) {
+	logs := &captureLog{}
+	SetLoggable(logs)
+	defer SetLoggable(nil)
+
+	// curl dumps are capped too, and the body is passed on as it's read
+	big := strings.Repeat("x", 2*curlMaxBody)
+	m := NewMockServer(t)
+	m.Expect("GET", "/big").RespondWith(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(big))
+	})
+	s, err := GetString2(context.Background(), m.URL+"/big", &RequestOptions{Debug: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != big {
+		t.Errorf("expected the whole body, got %v bytes", len(s))
+	}
+	l := logs.all()
+	if !strings.Contains(l, "...(truncated)") || len(l) > curlMaxBody+4096 {
+		t.Errorf("expected a truncated dump, got %v bytes", len(l))
+	}
+}
+
+func TestRedactPrefix(t *testing.T) {
+	fields := []string{"password", "token"}
+	for in, want := range map[string]string{
+		`{"user":"bob","password":"hun`:                `{"user":"bob","password":"REDACTED"`,
+		`{"password": "a\"b", "token":12345`:           `{"password": "REDACTED", "token":"REDACTED"`,
+		`{"Token":{"a":["x","y"]},"n":1,"pass`:         `{"Token":"REDACTED","n":1,"pass`,
+		`[{"token":"abc"},{"token"`:                    `[{"token":"REDACTED"},{"token"`,
+		`{"note":"password","x":"token: no","token": `: `{"note":"password","x":"token: no","token": "REDACTED"`,
+	} {
+		if got := string(redactPrefix([]byte(in), fields)); got != want {
+			t.Errorf("redactPrefix(%v) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestLoggingTransportForm(t *testing.T) {
+	logs := &captureLog{}
+	SetLoggable(logs)
+	defer SetLoggable(nil)
+
+	m := NewMockServer(t)
+	m.Expect("POST", "/token").Respond(200, map[string]any{"access_token": "tok123", "expires_in": 3600})
+	m.Expect("GET", "/me").Respond(200, map[string]any{"name": "bob"})
+	c := NewClient(m.URL)
+	c.HTTPClient = &http.Client{Transport: &LoggingTransport{Curl: true}}
+	c.Auth = &OAuth2ClientCredentials{
+		TokenURL:          m.URL + "/token",
+		ClientID:          "my-client",
+		ClientSecret:      "s3cr3t",
+		CredentialsInBody: true,
+		Client:            c,
+	}
+	if err := c.GetJSON(context.Background(), "/me", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	s := logs.all()
+	if !strings.Contains(s, "client_secret=REDACTED") || !strings.Contains(s, "client_id=my-client") {
+		t.Error("unexpected log", s)
+	}
+	if strings.Contains(s, "s3cr3t") || strings.Contains(s, "tok123") {
+		t.Error("expected secrets to be redacted", s)
+	}
+
+	// cut off bodies are redacted too
+	lt := &LoggingTransport{}
+	for in, want := range map[string]string{
+		"a=1&client_secret=abc&b=2": "a=1&client_secret=REDACTED&b=2",
+		"a=1&Password=abc":          "a=1&Password=REDACTED",
+		"client%5Fsecret=abc":       "client%5Fsecret=REDACTED",
+	} {
+		if got := lt.truncate([]byte(in), 1024, "application/x-www-form-urlencoded; charset=utf-8"); got != want {
+			t.Errorf("truncate(%v) = %v, want %v", in, got, want)
+		}
+	}
+	if got := lt.truncate([]byte("a=1&token=abcdef"), 12, "application/x-www-form-urlencoded"); got != "a=1&token=REDACTED...(truncated)" {
+		t.Errorf("unexpected truncated form %v", got)
+	}
+}
+
+func TestLoggingTransportCompressed(t *testing.T) {
+	logs := &captureLog{}
+	SetLoggable(logs)
+	defer SetLoggable(nil)
+
+	big := strings.Repeat("hello ", 1000)
+	ts := httptest.NewServer((&Compress{}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteObject(w, 200, map[string]any{"token": "abc123", "z": big})
+	})))
+	defer ts.Close()
+
+	c := &Client{HTTPClient: &http.Client{Transport: &LoggingTransport{}}}
+	got := map[string]any{}
+	if err := c.GetJSON(context.Background(), ts.URL, &got, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got["z"] != big {
+		t.Error("expected the decoded response")
+	}
+	s := logs.all()
+	if !strings.Contains(s, `"token":"REDACTED"`) || !strings.Contains(s, "hello hello") || !strings.Contains(s, "...(truncated)") {
+		t.Error("expected the decoded response in the log, got", s)
+	}
+	if strings.Contains(s, "abc123") {
+		t.Error("expected the token to be redacted", s)
+	}
+
+	if err := c.GetJSON(context.Background(), ts.URL, nil, &RequestOptions{Debug: true}); err != nil {
+		t.Fatal(err)
+	}
+	s = logs.all()
+	if !strings.Contains(s, "curl -X GET '"+ts.URL+"' --compressed") || !strings.Contains(s, "Content-Encoding: gzip") ||
+		!strings.Contains(s, big) {
+		t.Error("unexpected curl dump", s)
+	}
+}
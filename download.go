@@ -0,0 +1,218 @@
+package gotils
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned by Download when the downloaded file doesn't match DownloadOptions.Checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// DownloadOptions for Download.
+type DownloadOptions struct {
+	// Checksum to verify the file against, in the form "sha256:<hex>" or "md5:<hex>".
+	Checksum string
+	// Progress is called as the file is written. total is -1 if unknown.
+	Progress func(written, total int64)
+	// MaxAttempts is the number of times to try resuming if the connection drops part way through.
+	// Defaults to 3.
+	MaxAttempts int
+	// RequestOptions for each request, such as headers.
+	RequestOptions *RequestOptions
+}
+
+func (o *DownloadOptions) maxAttempts() int {
+	if o.MaxAttempts <= 0 {
+		return 3
+	}
+	return o.MaxAttempts
+}
+
+func (o *DownloadOptions) hasher() (hash.Hash, string, error) {
+	if o.Checksum == "" {
+		return nil, "", nil
+	}
+	algo, sum, ok := strings.Cut(o.Checksum, ":")
+	if !ok {
+		return nil, "", fmt.Errorf("invalid checksum %q, expected algorithm:hex", o.Checksum)
+	}
+	switch strings.ToLower(algo) {
+	case "sha256":
+		return sha256.New(), strings.ToLower(sum), nil
+	case "md5":
+		return md5.New(), strings.ToLower(sum), nil
+	}
+	return nil, "", fmt.Errorf("unsupported checksum algorithm %q", algo)
+}
+
+// Download downloads url to the file dest. It writes to dest + ".part" then renames it when done,
+// so dest is either the complete file or not there at all.
+//
+// If a ".part" file is left over from a previous attempt, or the connection drops part way through,
+// it resumes with a Range request if the server supports them. The ETag or Last-Modified time is kept
+// in a ".part.validator" file and sent as If-Range, so if the file has changed on the server it starts
+// over rather than mixing the two. Non-2xx responses return an HTTPError.
+//
+//	err := gotils.Download(ctx, url, "big.iso", &gotils.DownloadOptions{Checksum: "sha256:" + sum})
+func (c *Client) Download(ctx context.Context, url, dest string, opts *DownloadOptions) error {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	h, want, err := opts.hasher()
+	if err != nil {
+		return err
+	}
+	part := dest + ".part"
+	resumable := true // until we hear otherwise
+	for attempt := 1; ; attempt++ {
+		var done bool
+		done, resumable, err = c.downloadAttempt(ctx, url, part, resumable, opts)
+		if done {
+			break
+		}
+		if ctx.Err() != nil || attempt >= opts.maxAttempts() {
+			return err
+		}
+		var he HTTPError
+		if errors.As(err, &he) && he.Code() != http.StatusRequestedRangeNotSatisfiable {
+			return err
+		}
+		L(ctx).Info().Printf("download of %v interrupted, attempt %v of %v: %v", url, attempt, opts.maxAttempts(), err)
+	}
+
+	if h != nil {
+		f, err := os.Open(part)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		got := hex.EncodeToString(h.Sum(nil))
+		if got != want {
+			removePart(part)
+			return fmt.Errorf("%w for %v: expected %v, got %v", ErrChecksumMismatch, url, want, got)
+		}
+	}
+	if err := os.Rename(part, dest); err != nil {
+		return err
+	}
+	os.Remove(part + ".validator")
+	return nil
+}
+
+func removePart(part string) {
+	os.Remove(part)
+	os.Remove(part + ".validator")
+}
+
+// validator returns what to send as If-Range to resume a download of resp: a strong ETag, or the
+// Last-Modified time if there isn't one.
+func validator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// downloadAttempt makes one request, appending to part if it can resume. It returns whether the download
+// completed and whether the server supports resuming.
+func (c *Client) downloadAttempt(ctx context.Context, url, part string, resumable bool, opts *DownloadOptions) (bool, bool, error) {
+	var offset int64
+	// without a validator we can't tell if part is from the same version of the file
+	v, _ := os.ReadFile(part + ".validator")
+	if fi, err := os.Stat(part); err == nil && resumable && len(v) > 0 {
+		offset = fi.Size()
+	}
+	ropts := opts.RequestOptions
+	if offset > 0 {
+		ropts = withHeader(ropts, "Range", fmt.Sprintf("bytes=%d-", offset))
+		ropts.Headers["If-Range"] = string(v)
+	}
+	resp, err := c.send(ctx, url, http.MethodGet, nil, ropts)
+	if err != nil {
+		var he HTTPError
+		if errors.As(err, &he) && he.Code() == http.StatusRequestedRangeNotSatisfiable {
+			// the part file doesn't match what's on the server, start over
+			removePart(part)
+			return false, false, err
+		}
+		return false, resumable, err
+	}
+	defer resp.Body.Close()
+	resumable = resp.Header.Get("Accept-Ranges") == "bytes" || resp.StatusCode == http.StatusPartialContent
+	if v := validator(resp); v != "" && resumable {
+		if err := os.WriteFile(part+".validator", []byte(v), 0644); err != nil {
+			return false, resumable, err
+		}
+	} else {
+		os.Remove(part + ".validator")
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if resp.StatusCode == http.StatusPartialContent {
+		flags |= os.O_APPEND
+	} else {
+		// server sent the whole thing
+		flags |= os.O_TRUNC
+		offset = 0
+	}
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return false, resumable, err
+	}
+	defer f.Close()
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	if cr := resp.Header.Get("Content-Range"); cr != "" {
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			if n, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				total = n
+			}
+		}
+	}
+	var w io.Writer = f
+	if opts.Progress != nil {
+		opts.Progress(offset, total)
+		w = &progressWriter{w: f, written: offset, total: total, progress: opts.Progress}
+	}
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		return false, resumable, err
+	}
+	return true, resumable, f.Close()
+}
+
+type progressWriter struct {
+	w        io.Writer
+	written  int64
+	total    int64
+	progress func(written, total int64)
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.written += int64(n)
+	pw.progress(pw.written, pw.total)
+	return n, err
+}
+
+// Download downloads url to the file dest using the DefaultClient. See Client.Download.
+func Download(ctx context.Context, url, dest string, opts *DownloadOptions) error {
+	return DefaultClient.Download(ctx, url, dest, opts)
+}
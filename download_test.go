@@ -0,0 +1,100 @@
+package gotils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	sum := sha256.Sum256(content)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+	var ranges []string
+	etag := `"v1"`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/file" {
+			WriteError(w, 404, ErrNotFound)
+			return
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "file")
+	// pretend a previous download got half way
+	os.WriteFile(dest+".part", content[:4000], 0644)
+	os.WriteFile(dest+".part.validator", []byte(etag), 0644)
+	var written, total int64
+	err := Download(ctx, ts.URL+"/file", dest, &DownloadOptions{
+		Checksum: checksum,
+		Progress: func(w, t int64) { written, total = w, t },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=4000-" {
+		t.Error("expected a range request, got", ranges)
+	}
+	b, _ := os.ReadFile(dest)
+	if !bytes.Equal(b, content) {
+		t.Error("downloaded file doesn't match")
+	}
+	if written != int64(len(content)) || total != int64(len(content)) {
+		t.Errorf("unexpected progress %v of %v", written, total)
+	}
+	if _, err := os.Stat(dest + ".part"); !os.IsNotExist(err) {
+		t.Error("expected .part file to be gone")
+	}
+	if _, err := os.Stat(dest + ".part.validator"); !os.IsNotExist(err) {
+		t.Error("expected .part.validator file to be gone")
+	}
+
+	// the file changed on the server since the .part was written, so it starts over
+	stale := bytes.Repeat([]byte("x"), 4000)
+	for name, v := range map[string]string{"changed": `"v0"`, "no validator": ""} {
+		ranges = nil
+		dest := filepath.Join(dir, "file-"+name)
+		os.WriteFile(dest+".part", stale, 0644)
+		if v != "" {
+			os.WriteFile(dest+".part.validator", []byte(v), 0644)
+		}
+		if err := Download(ctx, ts.URL+"/file", dest, nil); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := os.ReadFile(dest)
+		if !bytes.Equal(b, content) {
+			t.Errorf("%v: expected the new file, not the two spliced together", name)
+		}
+		if name == "no validator" && (len(ranges) != 1 || ranges[0] != "") {
+			t.Errorf("%v: expected no range request, got %v", name, ranges)
+		}
+	}
+
+	dest2 := filepath.Join(dir, "file2")
+	err = Download(ctx, ts.URL+"/file", dest2, &DownloadOptions{Checksum: "md5:abc"})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Error("expected ErrChecksumMismatch, got", err)
+	}
+	if _, err := os.Stat(dest2); !os.IsNotExist(err) {
+		t.Error("expected no file after checksum mismatch")
+	}
+
+	err = Download(ctx, ts.URL+"/nope", dest2, nil)
+	var he HTTPError
+	if !errors.As(err, &he) || he.Code() != 404 {
+		t.Error("expected 404, got", err)
+	}
+}
@@ -1,12 +1,21 @@
 package gotils
 
 import (
+	"errors"
 	"fmt"
 )
 
 // ErrNotFound generic sentinel not found error
 var ErrNotFound = NewHTTPError("not found", 404)
 
+// ErrTimeout is wrapped into errors from the HTTP client when a request hits its deadline or timeout.
+// Check with errors.Is(err, gotils.ErrTimeout).
+var ErrTimeout = NewHTTPError("request timed out", 504)
+
+// ErrCanceled is wrapped into errors from the HTTP client when the request context was canceled.
+// Check with errors.Is(err, gotils.ErrCanceled).
+var ErrCanceled = errors.New("request canceled")
+
 // UserError let's you set a separate error message intended for the end user.
 // See UserErrorf for creating one.
 // eg:
@@ -65,6 +74,20 @@ func UserErrorf(rootErr error, format string, a ...interface{}) error {
 	return &userError{root: rootErr, userMsg: fmt.Sprintf(format, a...)}
 }
 
+// CodedUserErrorf returns a new UserError that's written with the HTTP status code, such as 401 or 403.
+func CodedUserErrorf(code int, rootErr error, format string, a ...interface{}) error {
+	return &codedUserError{userError: userError{root: rootErr, userMsg: fmt.Sprintf(format, a...)}, code: code}
+}
+
+type codedUserError struct {
+	userError
+	code int
+}
+
+func (ue *codedUserError) Code() int {
+	return ue.code
+}
+
 // DetailedError let's you add a more detailed message to an error
 type DetailedError struct {
 	Message string `json:"message"`
@@ -100,6 +123,69 @@ func (c *coded) Wrap(err error) {
 	c.err = err
 }
 
+// FieldErrorer is implemented by errors that have per field validation messages.
+// WriteError adds them to the response as "fields".
+type FieldErrorer interface {
+	error
+	FieldErrors() map[string]string
+}
+
+// RemoteError is an error response from another service, decoded by CheckError from what
+// WriteError wrote. It keeps the status code, details, action and field errors so if you return it
+// from your own handler, it's passed along to your caller intact.
+//
+// It satisfies HTTPError, Coded, UserError, UserMessage, ActionError and FieldErrorer. Action,
+// Details and FieldErrors are empty if the server didn't send them.
+type RemoteError struct {
+	msg     string
+	code    int
+	details string
+	action  *Action
+	fields  map[string]string
+}
+
+func (e *RemoteError) Error() string {
+	return e.msg
+}
+func (e *RemoteError) Code() int {
+	return e.code
+}
+func (e *RemoteError) UserError() string {
+	return e.msg
+}
+func (e *RemoteError) Message() string {
+	return e.msg
+}
+func (e *RemoteError) Action() *Action {
+	return e.action
+}
+func (e *RemoteError) Details() string {
+	return e.details
+}
+func (e *RemoteError) FieldErrors() map[string]string {
+	return e.fields
+}
+
+// Is lets errors.Is match sentinel HTTPErrors such as ErrNotFound that came from the remote service.
+func (e *RemoteError) Is(target error) bool {
+	he, ok := target.(HTTPError)
+	return ok && he.Code() == e.code && he.Error() == e.msg
+}
+
+func (e *RemoteError) envelope(code int) map[string]any {
+	m := map[string]any{"message": e.msg, "status": code}
+	if e.details != "" {
+		m["details"] = e.details
+	}
+	if e.action != nil {
+		m["action"] = e.action
+	}
+	if len(e.fields) > 0 {
+		m["fields"] = e.fields
+	}
+	return m
+}
+
 type InternalError interface {
 	Internal() error
 }
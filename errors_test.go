@@ -0,0 +1,74 @@
+package gotils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testActionError struct{}
+
+func (e *testActionError) Error() string { return "please log in" }
+func (e *testActionError) Action() *Action {
+	return &Action{Label: "Log in", Href: "/login"}
+}
+
+func TestRemoteError(t *testing.T) {
+	upstream := httptest.NewServer(ErrorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		switch r.URL.Path {
+		case "/action":
+			return C(r.Context()).SetCode(http.StatusUnauthorized).Error(&testActionError{})
+		case "/details":
+			return &DetailedError{Message: "bad thing", Details: "more about the bad thing"}
+		case "/user":
+			return UserErrorf(errors.New("internal stuff"), "name is required")
+		}
+		return ErrNotFound
+	}))
+	defer upstream.Close()
+
+	ctx := context.Background()
+	err := GetJSON2(ctx, upstream.URL+"/details", nil, nil)
+	var re *RemoteError
+	if !errors.As(err, &re) {
+		t.Fatal("expected RemoteError, got", err)
+	}
+	if re.Details() != "more about the bad thing" || re.Code() != 500 {
+		t.Error("unexpected details/code", re.Details(), re.Code())
+	}
+
+	err = GetJSON2(ctx, upstream.URL+"/user", nil, nil)
+	var ue UserError
+	if !errors.As(err, &ue) || ue.UserError() != "name is required" {
+		t.Error("expected UserError, got", err)
+	}
+	var coded Coded
+	if !errors.As(err, &coded) || coded.Code() != 400 {
+		t.Error("expected code 400, got", err)
+	}
+
+	err = GetJSON2(ctx, upstream.URL+"/nope", nil, nil)
+	if !errors.Is(err, ErrNotFound) {
+		t.Error("expected ErrNotFound, got", err)
+	}
+
+	// a service in the middle should pass errors through intact
+	middle := httptest.NewServer(ErrorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return GetJSON2(r.Context(), upstream.URL+r.URL.Path, nil, nil)
+	}))
+	defer middle.Close()
+	err = GetJSON2(ctx, middle.URL+"/action", nil, nil)
+	var ae ActionError
+	if !errors.As(err, &ae) || ae.Action() == nil || ae.Action().Href != "/login" {
+		t.Fatal("expected ActionError, got", err)
+	}
+	if !errors.As(err, &coded) || coded.Code() != 401 {
+		t.Error("expected code 401, got", err)
+	}
+	var um UserMessage
+	if !errors.As(err, &um) || um.Message() != "please log in" {
+		t.Error("expected UserMessage, got", err)
+	}
+}
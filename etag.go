@@ -0,0 +1,143 @@
+package gotils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Versioned objects supply their own ETag, such as a revision number or updated timestamp, instead of
+// one being computed from the encoded JSON.
+type Versioned interface {
+	Version() string
+}
+
+// ETagOf returns a strong ETag for obj, from Version if obj is Versioned, otherwise a hash of its JSON.
+// It's the same ETag WriteObjectETag and ObjectHandler send, so use it with CheckIfMatch for updates:
+//
+//	etag, err := gotils.ETagOf(current)
+//	if err := gotils.CheckIfMatch(r, etag); err != nil {
+//	    return err // 412
+//	}
+func ETagOf(obj interface{}) (string, error) {
+	if v, ok := obj.(Versioned); ok && v.Version() != "" {
+		return quoteETag(v.Version()), nil
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return hashETag(b), nil
+}
+
+func hashETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func quoteETag(v string) string {
+	if strings.HasPrefix(v, `"`) || strings.HasPrefix(v, `W/"`) {
+		return v
+	}
+	return `"` + v + `"`
+}
+
+// WriteObjectETag is WriteObject with an ETag header. For GET and HEAD requests, if the client's
+// If-None-Match has the same ETag it responds with 304 Not Modified and no body.
+func WriteObjectETag(w http.ResponseWriter, r *http.Request, code int, obj interface{}) error {
+	if v, ok := obj.(Versioned); ok && v.Version() != "" {
+		return writeObjectETag(w, r, code, obj, quoteETag(v.Version()))
+	}
+	return writeObjectETag(w, r, code, obj, "")
+}
+
+// writeObjectETag writes obj with etag, or a hash of the body if etag is empty.
+func writeObjectETag(w http.ResponseWriter, r *http.Request, code int, obj interface{}, etag string) error {
+	jsonValue, err := json.Marshal(obj)
+	if err != nil {
+		log.Printf("ERROR: marshalling JSON in WriteObjectETag: %v", err)
+		return err
+	}
+	if etag == "" {
+		etag = hashETag(jsonValue)
+	}
+	w.Header().Set("ETag", etag)
+	if code == http.StatusOK && (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		etagMatches(r.Header.Get("If-None-Match"), etag, false) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_, err = w.Write(jsonValue)
+	if err != nil {
+		log.Printf("ERROR: error writing response: %v", err)
+		return err
+	}
+	return nil
+}
+
+// CheckIfMatch returns an HTTPError with code 412 if r has an If-Match header that doesn't match etag,
+// the current ETag of the resource. Pass an empty etag if the resource doesn't exist. Requests without
+// If-Match pass.
+func CheckIfMatch(r *http.Request, etag string) error {
+	h := r.Header.Get("If-Match")
+	if h == "" {
+		return nil
+	}
+	if etag == "" || !etagMatches(h, etag, true) {
+		return NewHTTPError("precondition failed", http.StatusPreconditionFailed)
+	}
+	return nil
+}
+
+// encodedETag returns the ETag Compress sends for a response with a strong etag encoded with enc, such
+// as "abc-gzip", so each encoding has its own strong ETag. Weak ETags are left alone.
+func encodedETag(etag, enc string) string {
+	if len(etag) < 2 || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + enc + `"`
+}
+
+// decodedETag removes the suffix encodedETag adds.
+func decodedETag(etag string) string {
+	for _, enc := range []string{"gzip", "deflate"} {
+		if s, ok := strings.CutSuffix(etag, "-"+enc+`"`); ok {
+			return s + `"`
+		}
+	}
+	return etag
+}
+
+// etagMatches returns whether etag is in header, a comma separated list of ETags or "*". Strong
+// comparison, used for If-Match, doesn't match weak ETags. ETags from Compress for an encoded response
+// match the unencoded etag.
+func etagMatches(header, etag string, strong bool) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			return true
+		}
+		if strings.HasPrefix(t, "W/") {
+			if strong {
+				continue
+			}
+			t = t[2:]
+		}
+		if t == etag || decodedETag(t) == etag {
+			return true
+		}
+	}
+	return false
+}
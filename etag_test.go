@@ -0,0 +1,133 @@
+package gotils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+type etagThing struct {
+	Name string `json:"name"`
+}
+
+func (t *etagThing) ObjectName() string { return "thing" }
+
+type versionedThing struct {
+	Name string `json:"name"`
+	Rev  int    `json:"rev"`
+}
+
+func (t *versionedThing) ObjectName() string { return "thing" }
+func (t *versionedThing) Version() string    { return "rev-" + strconv.Itoa(t.Rev) }
+
+func TestObjectHandlerETag(t *testing.T) {
+	thing := &etagThing{Name: "a"}
+	h := ObjectHandler(func(w http.ResponseWriter, r *http.Request) (ObjectNamer, error) {
+		return thing, nil
+	})
+	do := func(method, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/thing", nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	w := do("GET", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || etag == "" || w.Body.String() != `{"thing":{"name":"a"}}` {
+		t.Fatalf("unexpected response %v %v %q", w.Code, etag, w.Body.String())
+	}
+	if want, _ := ETagOf(thing); want != etag {
+		t.Errorf("ETagOf %v doesn't match header %v", want, etag)
+	}
+
+	for _, inm := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+		w = do("GET", inm)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %v: expected 304, got %v %q", inm, w.Code, w.Body.String())
+		}
+	}
+	if w = do("GET", `"other"`); w.Code != 200 {
+		t.Errorf("expected 200 for a different ETag, got %v", w.Code)
+	}
+	// only safe methods get a 304
+	if w = do("POST", etag); w.Code != 200 {
+		t.Errorf("expected 200 for POST, got %v", w.Code)
+	}
+
+	thing.Name = "b"
+	if w = do("GET", etag); w.Code != 200 || w.Header().Get("ETag") == etag {
+		t.Errorf("expected a new ETag after a change, got %v %v", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestWriteObjectETagVersioned(t *testing.T) {
+	v := &versionedThing{Name: "a", Rev: 3}
+	r := httptest.NewRequest("GET", "/thing", nil)
+	w := httptest.NewRecorder()
+	if err := WriteObjectETag(w, r, 200, v); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Header().Get("ETag"); got != `"rev-3"` {
+		t.Errorf("expected version ETag, got %v", got)
+	}
+
+	r.Header.Set("If-None-Match", `"rev-3"`)
+	w = httptest.NewRecorder()
+	WriteObjectETag(w, r, 200, v)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected 304, got %v", w.Code)
+	}
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	v := &versionedThing{Name: "a", Rev: 3}
+	etag, _ := ETagOf(v)
+	for ifMatch, ok := range map[string]bool{
+		"":                 true,
+		`"rev-3"`:          true,
+		`"rev-2", "rev-3"`: true,
+		"*":                true,
+		`"rev-3-gzip"`:     true, // from a Compress response
+		`"rev-2"`:          false,
+		`W/"rev-3"`:        false, // If-Match is a strong comparison
+	} {
+		r := httptest.NewRequest("PUT", "/thing", nil)
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		err := CheckIfMatch(r, etag)
+		if ok && err != nil {
+			t.Errorf("If-Match %v: unexpected error %v", ifMatch, err)
+		}
+		if !ok {
+			herr, isHTTP := err.(HTTPError)
+			if !isHTTP || herr.Code() != http.StatusPreconditionFailed {
+				t.Errorf("If-Match %v: expected 412, got %v", ifMatch, err)
+			}
+		}
+	}
+
+	// "*" needs the resource to exist
+	r := httptest.NewRequest("PUT", "/thing", nil)
+	r.Header.Set("If-Match", "*")
+	if err := CheckIfMatch(r, ""); err == nil {
+		t.Error("expected 412 for a missing resource")
+	}
+
+	// the error handler responds with the code
+	h := ErrorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return CheckIfMatch(r, etag)
+	})
+	r = httptest.NewRequest("PUT", "/thing", nil)
+	r.Header.Set("If-Match", `"rev-1"`)
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 response, got %v", w.Code)
+	}
+}
@@ -0,0 +1,104 @@
+package gotils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// Response is a decoded response along with the status code, headers and raw body.
+type Response[T any] struct {
+	StatusCode int
+	Header     http.Header
+	// Body is the raw response body.
+	Body []byte
+	// Value is Body decoded as JSON. It's the zero value if the body was empty.
+	Value T
+}
+
+// DoResponse performs a request like Client.Do, but returns the decoded value along with the
+// response metadata. c can be nil to use DefaultClient. If the server responds with an error status,
+// the Response is returned along with the error, with StatusCode, Header and Body set but not Value.
+//
+//	resp, err := gotils.DoResponse[User](ctx, c, "/users/123", "GET", nil, nil)
+//	fmt.Println(resp.StatusCode, resp.Header.Get("ETag"), resp.Value.Name)
+func DoResponse[T any](ctx context.Context, c *Client, url string, method string, body io.Reader, opts *RequestOptions) (*Response[T], error) {
+	if c == nil {
+		c = DefaultClient
+	}
+	resp, err := c.send(ctx, url, method, body, opts)
+	if resp == nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	r := &Response[T]{StatusCode: resp.StatusCode, Header: resp.Header}
+	if err != nil {
+		// the body has already been read into memory
+		r.Body, _ = io.ReadAll(resp.Body)
+		return r, err
+	}
+	r.Body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, C(ctx).Errorf("couldn't read response: %w", err)
+	}
+	if len(bytes.TrimSpace(r.Body)) > 0 {
+		err = ParseJSONBytes(r.Body, &r.Value)
+		if err != nil {
+			return r, C(ctx).Errorf("couldn't parse response: %w", err)
+		}
+	}
+	return r, nil
+}
+
+func doValue[T any](ctx context.Context, c *Client, url, method string, in any, opts *RequestOptions) (T, error) {
+	var body io.Reader
+	if in != nil {
+		jsonValue, err := json.Marshal(in)
+		if err != nil {
+			var zero T
+			return zero, C(ctx).Error(err)
+		}
+		body = bytes.NewReader(jsonValue)
+	}
+	r, err := DoResponse[T](ctx, c, url, method, body, opts)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return r.Value, nil
+}
+
+// Get performs a GET request with c and returns the response decoded as a T. c can be nil to use
+// DefaultClient.
+//
+//	user, err := gotils.Get[*User](ctx, c, "/users/123", nil)
+func Get[T any](ctx context.Context, c *Client, url string, opts *RequestOptions) (T, error) {
+	return doValue[T](ctx, c, url, http.MethodGet, nil, opts)
+}
+
+// Post performs a POST request with in as the JSON body and returns the response decoded as an Out.
+// c can be nil to use DefaultClient.
+func Post[In, Out any](ctx context.Context, c *Client, url string, in In, opts *RequestOptions) (Out, error) {
+	return doValue[Out](ctx, c, url, http.MethodPost, in, opts)
+}
+
+// Put performs a PUT request with in as the JSON body and returns the response decoded as an Out.
+// c can be nil to use DefaultClient.
+func Put[In, Out any](ctx context.Context, c *Client, url string, in In, opts *RequestOptions) (Out, error) {
+	return doValue[Out](ctx, c, url, http.MethodPut, in, opts)
+}
+
+// Patch performs a PATCH request with in as the JSON body and returns the response decoded as an Out.
+// c can be nil to use DefaultClient.
+func Patch[In, Out any](ctx context.Context, c *Client, url string, in In, opts *RequestOptions) (Out, error) {
+	return doValue[Out](ctx, c, url, http.MethodPatch, in, opts)
+}
+
+// Delete performs a DELETE request and returns the response decoded as a T. c can be nil to use
+// DefaultClient.
+func Delete[T any](ctx context.Context, c *Client, url string, opts *RequestOptions) (T, error) {
+	return doValue[T](ctx, c, url, http.MethodDelete, nil, opts)
+}
@@ -1,19 +1,16 @@
 package gotils
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"mime/multipart"
 	"net/http"
-	urlp "net/url"
 	"os"
 	"strconv"
-	"strings"
+	"time"
 )
 
 // Port will check env var for PORT (common on cloud services) and use that, otherwise
@@ -71,6 +68,14 @@ func handleErr(w http.ResponseWriter, err error) {
 	var ue UserError
 	if errors.As(err, &ue) {
 		code = http.StatusBadRequest
+		if c, ok := ue.(Coded); ok {
+			code = c.Code()
+		}
+		if re, ok := ue.(*RemoteError); ok {
+			// came from another service, pass it along as is
+			WriteError(w, code, re)
+			return
+		}
 		err = errors.New(ue.UserError())
 	}
 	var he HTTPError
@@ -85,6 +90,10 @@ func handleErr(w http.ResponseWriter, err error) {
 	if errors.As(err, &um) {
 		err = um // ensure we use the proper message
 	}
+	var ae ActionError
+	if errors.As(err, &ae) {
+		err = ae // so WriteError includes the action
+	}
 	WriteError(w, code, err)
 }
 
@@ -96,6 +105,7 @@ type ObjectHandlerFunc func(w http.ResponseWriter, r *http.Request) (ObjectNamer
 
 // ErrorHandler a generic error handler that will respond with a generic error response
 // Set a logger/printer with gotils.SetPrintfer() to have this log to your logger
+// Responses have an ETag, see ETagOf, and GETs with a matching If-None-Match get a 304.
 func ObjectHandler(h ObjectHandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		v, err := h(w, r)
@@ -107,8 +117,13 @@ func ObjectHandler(h ObjectHandlerFunc) http.HandlerFunc {
 			handleErr(w, ErrNotFound)
 			return
 		}
-		// respond with object
-		WriteObject(w, 200, map[string]interface{}{v.ObjectName(): v})
+		// respond with object, tagged so clients can poll with If-None-Match
+		etag, err := ETagOf(v)
+		if err != nil {
+			handleErr(w, err)
+			return
+		}
+		writeObjectETag(w, r, 200, map[string]interface{}{v.ObjectName(): v}, etag)
 	}
 }
 
@@ -116,6 +131,10 @@ func WriteError(w http.ResponseWriter, code int, err error) error {
 	switch err := err.(type) {
 	case *DetailedError:
 		return WriteObject(w, code, map[string]interface{}{"error": err})
+	case *RemoteError:
+		return WriteObject(w, code, map[string]interface{}{"error": err.envelope(code)})
+	case FieldErrorer:
+		return WriteObject(w, code, map[string]interface{}{"error": map[string]any{"message": err.Error(), "status": code, "fields": err.FieldErrors()}})
 	case ActionError:
 		return WriteObject(w, code, map[string]interface{}{"error": map[string]any{"message": err.Error(), "status": code, "action": err.Action()}})
 	default:
@@ -145,7 +164,12 @@ func WriteObject(w http.ResponseWriter, code int, obj interface{}) error {
 	return nil
 }
 
+// ParseJSON parses the JSON request body into t. gzip and deflate encoded bodies are decoded, up to
+// MaxDecompressedSize.
 func ParseJSON(w http.ResponseWriter, r *http.Request, t interface{}) error {
+	if err := decompressRequest(r, MaxDecompressedSize); err != nil {
+		return err
+	}
 	err := ParseJSONReader(r.Body, t)
 	if err != nil {
 		return err
@@ -153,25 +177,23 @@ func ParseJSON(w http.ResponseWriter, r *http.Request, t interface{}) error {
 	return nil
 }
 
+// GetBytes performs a GET request and returns the response body.
 func GetBytes(url string) ([]byte, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+	return GetBytes2(context.Background(), url, nil)
+}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		if err != nil {
-			return nil, err
-		}
-		return nil, NewHTTPError(fmt.Sprintf("Error response %v: %v", resp.StatusCode, string(bodyBytes)), resp.StatusCode)
-	}
-	return bodyBytes, nil
+// GetBytes2 is GetBytes with a context and options.
+func GetBytes2(ctx context.Context, url string, opts *RequestOptions) ([]byte, error) {
+	return DefaultClient.GetBytes(ctx, url, opts)
 }
 
 func GetString(url string) (string, error) {
-	b, err := GetBytes(url)
+	return GetString2(context.Background(), url, nil)
+}
+
+// GetString2 is GetString with a context and options.
+func GetString2(ctx context.Context, url string, opts *RequestOptions) (string, error) {
+	b, err := GetBytes2(ctx, url, opts)
 	if err != nil {
 		return "", err
 	}
@@ -179,206 +201,93 @@ func GetString(url string) (string, error) {
 }
 
 // GetJSON performs a get request and then parses the result into t
+// ipfs:// and other URLs registered with RegisterGateways are fetched through their gateways.
 func GetJSON(url string, t interface{}) error {
-	if strings.HasPrefix(url, "ipfs://") {
-		path := strings.TrimPrefix(url, "ipfs://")
-		// url = fmt.Sprintf("https://cloudflare-ipfs.com/ipfs/%v", path)
-		// cloudflare giving me captcha response!
-		url = fmt.Sprintf("https://ipfs.io/ipfs/%v", path)
-		fmt.Println("URL:", url)
-	}
-	resp, err := http.Get(url)
-	if err != nil {
-		return C(context.Background()).Error(err)
-	}
-	defer resp.Body.Close()
-
-	err = CheckError(resp)
-	if err != nil {
-		return C(context.Background()).Error(err)
-	}
-
-	err = ParseJSONReader(resp.Body, t)
-	if err != nil {
-		return C(context.Background()).Errorf("couldn't parse response: %v", err)
-	}
-	return nil
+	return GetJSONOpts(url, t, nil)
 }
 
 type RequestOptions struct {
 	Headers map[string]string
+	// Timeout for this request including reading the response body, overrides Client.Timeout.
+	// When exceeded, the returned error wraps ErrTimeout. With retries, this applies to each attempt.
+	Timeout time.Duration
+	// Retry overrides Client.Retry for this request.
+	Retry *RetryPolicy
+	// Limiter overrides Client.Limiter for this request.
+	Limiter *RateLimiter
+	// UploadProgress is called as the request body is sent by PostMultipart. total is -1 if unknown.
+	UploadProgress func(sent, total int64)
+	// VerifyCID checks that content fetched from an ipfs:// URL matches its CID, trying the next
+	// gateway if it doesn't. Only base32 CIDv1s with the raw codec can be verified.
+	VerifyCID bool
+	// Debug logs this request as a curl command along with the full response, see LoggingTransport.
+	// Setting the GOTILS_HTTP_DEBUG environment variable does this for every request.
+	Debug bool
+	// Auth adds credentials to this request, overriding Client.Auth.
+	Auth Authenticator
+	// Sign signs this request with HMAC-SHA256, overriding Client.Sign.
+	Sign *HMACSigner
+	// CompressBody gzips request bodies of 1 KB or more. Only use it if the server can decode them,
+	// as servers using Compress or ParseJSON can.
+	CompressBody bool
 }
 
 // GetJSON performs a get request and then parses the result into t
 func GetJSONOpts(url string, t interface{}, opts *RequestOptions) error {
-	req, err := http.NewRequest("GET", url, nil)
+	err := DefaultClient.GetJSON(context.Background(), url, t, opts)
 	if err != nil {
 		return C(context.Background()).Error(err)
 	}
-	// req.Header = http.Header{
-	// "Host":          []string{"www.host.com"},
-	// "Content-Type":  []string{"application/json"},
-	// "Authorization": []string{"Bearer Token"},
-	// }
-	if opts == nil {
-		opts = &RequestOptions{
-			Headers: map[string]string{},
-		}
-	}
-	for k, v := range opts.Headers {
-		req.Header.Set(k, v)
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return C(context.Background()).Error(err)
-	}
-	defer resp.Body.Close()
-
-	err = CheckError(resp)
-	if err != nil {
-		return C(context.Background()).Error(err)
-	}
-
-	err = ParseJSONReader(resp.Body, t)
-	if err != nil {
-		return C(context.Background()).Errorf("couldn't parse response: %v", err)
-	}
 	return nil
 }
 
 func GetJSON2(ctx context.Context, url string, tout any, opts *RequestOptions) error {
-	return do(ctx, url, "GET", nil, tout, opts)
+	return DefaultClient.GetJSON(ctx, url, tout, opts)
 }
 
 // PostJSON performs a post request with tin as the body then parses the response into tout. tin and tout can be the same object.
 func PostJSON(url string, tin, tout interface{}) error {
-	jsonValue, err := json.Marshal(tin)
-	if err != nil {
-		return C(context.Background()).Error(err)
-	}
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonValue))
-	if err != nil {
-		return C(context.Background()).Error(err)
-	}
-	defer resp.Body.Close()
-
-	err = CheckError(resp)
-	if err != nil {
-		return C(context.Background()).Error(err)
-	}
-	if tout != nil {
-		err = ParseJSONReader(resp.Body, tout)
-		if err != nil {
-			return fmt.Errorf("couldn't parse response: %v", err)
-		}
-	}
-	return nil
+	return PostJSONOpts(url, tin, tout, nil)
 }
 
 // GetJSON performs a get request and then parses the result into t
 func PostJSONOpts(url string, tin, tout interface{}, opts *RequestOptions) error {
-	jsonValue, err := json.Marshal(tin)
-	if err != nil {
-		return C(context.Background()).Error(err)
-	}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonValue))
+	err := DefaultClient.PostJSON(context.Background(), url, tin, tout, opts)
 	if err != nil {
 		return C(context.Background()).Error(err)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if opts == nil {
-		opts = &RequestOptions{
-			Headers: map[string]string{},
-		}
-	}
-	for k, v := range opts.Headers {
-		req.Header.Set(k, v)
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return C(context.Background()).Error(err)
-	}
-	defer resp.Body.Close()
-
-	err = CheckError(resp)
-	if err != nil {
-		return C(context.Background()).Error(err)
-	}
-
-	err = ParseJSONReader(resp.Body, tout)
-	if err != nil {
-		return C(context.Background()).Errorf("couldn't parse response: %v", err)
-	}
 	return nil
 }
 
 // PostJSON2 performs a post request with tin as the body then parses the response into tout. tin and tout can be the same object.
 func PostJSON2(ctx context.Context, url string, tin, tout any, opts *RequestOptions) error {
-	jsonValue, err := json.Marshal(tin)
-	if err != nil {
-		return err
-	}
-	body := bytes.NewBuffer(jsonValue)
-	return do(ctx, url, "POST", body, tout, opts)
+	return DefaultClient.PostJSON(ctx, url, tin, tout, opts)
 }
 
-func PostMultipartForm(ctx context.Context, url string, formValues map[string]string, tout any, opts *RequestOptions) error {
-	// body := strings.NewReader(form.Encode())
-	b := &bytes.Buffer{}
-	mp := multipart.NewWriter(b)
-	form := urlp.Values{}
-	for k, v := range formValues {
-		err := mp.WriteField(k, v)
-		if err != nil {
-			return err
-		}
-		form.Add(k, v)
-	}
-	mp.Close()
-
-	bod := bytes.NewReader(b.Bytes())
-	// bod := strings.NewReader(form.Encode())
-	opts.Headers["Content-Type"] = mp.FormDataContentType()
-	return do(ctx, url, "POST", bod, tout, opts)
+// PutJSON2 performs a PUT request with tin as the body then parses the response into tout. tin and tout can be the same object.
+func PutJSON2(ctx context.Context, url string, tin, tout any, opts *RequestOptions) error {
+	return DefaultClient.PutJSON(ctx, url, tin, tout, opts)
 }
 
-// PatchJSON performs a PATCH request with tin as the body then parses the response into tout. tin and tout can be the same object.
-func PatchJSON(url string, tin, tout interface{}) error {
-
-	jsonValue, err := json.Marshal(tin)
-	if err != nil {
-		return C(context.Background()).Error(err)
-	}
+// PatchJSON2 performs a PATCH request with tin as the body then parses the response into tout. tin and tout can be the same object.
+func PatchJSON2(ctx context.Context, url string, tin, tout any, opts *RequestOptions) error {
+	return DefaultClient.PatchJSON(ctx, url, tin, tout, opts)
+}
 
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(jsonValue))
-	req.Header.Set("Content-Type", "application/json")
-	if err != nil {
-		return C(context.Background()).Error(err)
-	}
+// DeleteJSON2 performs a DELETE request and parses the response into tout if it's not nil.
+func DeleteJSON2(ctx context.Context, url string, tout any, opts *RequestOptions) error {
+	return DefaultClient.DeleteJSON(ctx, url, tout, opts)
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return C(context.Background()).Error(err)
-	}
-	// resp, err := http.Patch(url, "application/json", bytes.NewBuffer(jsonValue))
-	// if err != nil {
-	// 	return err
-	// }
-	defer resp.Body.Close()
+// PostMultipartForm posts formValues as multipart/form-data. See PostMultipart to upload files too.
+func PostMultipartForm(ctx context.Context, url string, formValues map[string]string, tout any, opts *RequestOptions) error {
+	return DefaultClient.PostMultipart(ctx, url, formValues, nil, tout, opts)
+}
 
-	err = CheckError(resp)
-	if err != nil {
-		return err
-	}
-	if tout != nil {
-		err = ParseJSONReader(resp.Body, tout)
-		if err != nil {
-			return fmt.Errorf("couldn't parse response: %v", err)
-		}
-	}
-	return nil
+// PatchJSON performs a PATCH request with tin as the body then parses the response into tout. tin and tout can be the same object.
+// Note: this used to send a PUT. See PatchMerge and PatchOps for proper patch formats.
+func PatchJSON(url string, tin, tout interface{}) error {
+	return PatchJSON2(context.Background(), url, tin, tout, nil)
 }
 
 func do(ctx context.Context, url string, method string, body io.Reader, tout any, opts *RequestOptions) error {
@@ -387,40 +296,22 @@ func do(ctx context.Context, url string, method string, body io.Reader, tout any
 
 // generic function to do any http method
 func Do(ctx context.Context, url string, method string, body io.Reader, tout any, opts *RequestOptions) error {
-	if opts == nil {
-		opts = &RequestOptions{
-			Headers: map[string]string{},
-		}
-	}
-	client := http.DefaultClient
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return C(ctx).Errorf("NewRequest: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	for k, v := range opts.Headers {
-		req.Header.Set(k, v)
-	}
-	resp, err := client.Do(req)
-	// resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonValue))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+	return DefaultClient.Do(ctx, url, method, body, tout, opts)
+}
 
-	err = CheckError(resp)
-	if err != nil {
-		return err
-	}
-	if tout != nil {
-		err = ParseJSONReader(resp.Body, tout)
-		if err != nil {
-			return C(ctx).Errorf("couldn't parse response: %w", err)
-		}
-	}
-	return nil
+// remoteErrorResponse is everything WriteError might write. Status isn't included since
+// firebase style errors use a string there, the response status code is used instead.
+type remoteErrorResponse struct {
+	Error *struct {
+		Message string            `json:"message"`
+		Details string            `json:"details"`
+		Action  *Action           `json:"action"`
+		Fields  map[string]string `json:"fields"`
+	} `json:"error"`
 }
 
+// CheckError returns an error if the response isn't a 2xx. If the body is an error written by
+// WriteError, the error is a *RemoteError with all the details, otherwise it's an HTTPError.
 func CheckError(resp *http.Response) error {
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		bodyBytes, err := io.ReadAll(resp.Body)
@@ -428,11 +319,17 @@ func CheckError(resp *http.Response) error {
 			return err
 		}
 		// attempt to parse JSON
-		er := &ErrorResponse{}
+		er := &remoteErrorResponse{}
 		err2 := ParseJSONBytes(bodyBytes, er)
 		if err2 == nil {
 			if er.Error != nil && er.Error.Message != "" {
-				return NewHTTPError(er.Error.Message, resp.StatusCode)
+				return &RemoteError{
+					msg:     er.Error.Message,
+					code:    resp.StatusCode,
+					details: er.Error.Details,
+					action:  er.Error.Action,
+					fields:  er.Error.Fields,
+				}
 			}
 		}
 		// couldn't parse or no message, so just send regular error
@@ -443,10 +340,13 @@ func CheckError(resp *http.Response) error {
 
 // DownloadFile will download a url to a local file. It's efficient because it will
 // write as it downloads and not load the whole file into memory.
+// Note: filepath is used as the pattern for os.CreateTemp, the file is written to the temp directory.
+//
+// Deprecated: use Download which writes to the path you give it, can resume and verify a checksum.
 func DownloadFile(filepath string, url string) (*os.File, error) {
 
 	// Get the data
-	resp, err := http.Get(url)
+	resp, err := DefaultClient.send(context.Background(), url, http.MethodGet, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,485 @@
+package gotils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const claimsContextKey = contextKey("jwt_claims")
+
+// Claims are the verified claims from a JWT. The registered claims are parsed into fields, and every
+// claim is in Raw. Use ClaimsFromContext to get them in a handler, or DecodeClaims for your own type.
+type Claims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  Audience    `json:"aud,omitempty"`
+	ExpiresAt NumericDate `json:"exp,omitempty"`
+	NotBefore NumericDate `json:"nbf,omitempty"`
+	IssuedAt  NumericDate `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+	// Scope is the space separated "scope" claim, or the "scp" claim.
+	Scope string `json:"-"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+// Scopes returns the scopes in the token.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// Decode decodes all the claims into v, for custom claims.
+func (c *Claims) Decode(v any) error {
+	return json.Unmarshal(c.Raw, v)
+}
+
+// Audience is the "aud" claim, which can be a string or an array of strings.
+type Audience []string
+
+// UnmarshalJSON accepts a string or an array.
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+// NumericDate is a JWT time in seconds since the epoch. Fractional seconds are allowed in tokens, and
+// are dropped.
+type NumericDate int64
+
+// UnmarshalJSON accepts an integer or a fractional number.
+func (d *NumericDate) UnmarshalJSON(b []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	if i, err := n.Int64(); err == nil {
+		*d = NumericDate(i)
+		return nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return err
+	}
+	*d = NumericDate(f)
+	return nil
+}
+
+// Time returns d as a time.Time.
+func (d NumericDate) Time() time.Time {
+	return time.Unix(int64(d), 0)
+}
+
+// ClaimsFromContext returns the claims put on the context by JWTVerifier, or nil.
+func ClaimsFromContext(ctx context.Context) *Claims {
+	c, _ := ctx.Value(claimsContextKey).(*Claims)
+	return c
+}
+
+// DecodeClaims decodes the claims on the context into v, for custom claims.
+func DecodeClaims(ctx context.Context, v any) error {
+	c := ClaimsFromContext(ctx)
+	if c == nil {
+		return errors.New("no claims in context")
+	}
+	return c.Decode(v)
+}
+
+// JWTVerifier is middleware that verifies the bearer token on requests. It supports HS256, RS256
+// and ES256, with keys set directly or fetched from a JWKS URL. Invalid tokens get an HTTPError with
+// code 401, valid tokens without the required Scopes get a 403.
+//
+// The claims are put on the request context, see ClaimsFromContext, and the subject is added to the
+// context fields as "sub" so it shows up in logs and errors.
+//
+//	v := &gotils.JWTVerifier{JWKSURL: "https://auth.example.com/.well-known/jwks.json", Issuer: "https://auth.example.com/", Audience: "my-api"}
+//	http.Handle("/me", gotils.ErrorHandler(v.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+//		claims := gotils.ClaimsFromContext(r.Context())
+//		...
+//	})))
+type JWTVerifier struct {
+	// Key verifies tokens without a matching key ID. It's a []byte secret for HS256, an *rsa.PublicKey
+	// for RS256 or an *ecdsa.PublicKey for ES256.
+	Key any
+	// Keys by key ID ("kid").
+	Keys map[string]any
+	// JWKSURL is fetched for keys, and fetched again after JWKSRefresh or when a token has an unknown key ID.
+	JWKSURL string
+	// JWKSRefresh defaults to 1 hour.
+	JWKSRefresh time.Duration
+	// Client fetches the JWKS, defaults to DefaultClient.
+	Client *Client
+
+	// Issuer, if set, must match the "iss" claim.
+	Issuer string
+	// Audience, if set, must be in the "aud" claim.
+	Audience string
+	// Scopes must all be in the token, otherwise the request gets a 403.
+	Scopes []string
+	// Skew is how much clock difference to allow when checking exp and nbf. Defaults to 1 minute.
+	Skew time.Duration
+
+	mu        sync.Mutex
+	jwks      map[string]any
+	jwksAt    time.Time
+	jwksTried time.Time
+	// jwksFetch is closed when the JWKS fetch in progress is done
+	jwksFetch chan struct{}
+	jwksErr   error
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (v *JWTVerifier) skew() time.Duration {
+	if v.Skew <= 0 {
+		return time.Minute
+	}
+	return v.Skew
+}
+
+func invalidToken(format string, a ...any) error {
+	return NewHTTPError("invalid token: "+fmt.Sprintf(format, a...), http.StatusUnauthorized)
+}
+
+// Verify checks token and returns its claims. Errors are HTTPErrors with code 401 or 403.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalidToken("malformed")
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, invalidToken("malformed header")
+	}
+	h := &jwtHeader{}
+	if err := json.Unmarshal(hb, h); err != nil {
+		return nil, invalidToken("malformed header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalidToken("malformed signature")
+	}
+	key, err := v.key(ctx, h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(h.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, invalidToken("malformed claims")
+	}
+	claims := &Claims{Raw: payload}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, invalidToken("malformed claims")
+	}
+	// scope is usually a space separated string, but some use arrays, and Azure AD and others use scp
+	var scopes struct {
+		Scope Audience `json:"scope"`
+		Scp   Audience `json:"scp"`
+	}
+	if json.Unmarshal(payload, &scopes) == nil {
+		claims.Scope = strings.Join(append(scopes.Scope, scopes.Scp...), " ")
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt != 0 && now.After(claims.ExpiresAt.Time().Add(v.skew())) {
+		return nil, invalidToken("expired")
+	}
+	if claims.NotBefore != 0 && now.Before(claims.NotBefore.Time().Add(-v.skew())) {
+		return nil, invalidToken("not valid yet")
+	}
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return nil, invalidToken("wrong issuer")
+	}
+	if v.Audience != "" && !contains(claims.Audience, v.Audience) {
+		return nil, invalidToken("wrong audience")
+	}
+	for _, s := range v.Scopes {
+		if !contains(claims.Scopes(), s) {
+			return nil, NewHTTPError(fmt.Sprintf("token is missing scope %q", s), http.StatusForbidden)
+		}
+	}
+	return claims, nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, s2 := range ss {
+		if s2 == s {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyJWTSignature(alg string, key any, signed string, sig []byte) error {
+	hash := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "HS256":
+		k, ok := key.([]byte)
+		if !ok {
+			return invalidToken("wrong key type for %v", alg)
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return invalidToken("bad signature")
+		}
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return invalidToken("wrong key type for %v", alg)
+		}
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], sig) != nil {
+			return invalidToken("bad signature")
+		}
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return invalidToken("wrong key type for %v", alg)
+		}
+		if len(sig) != 64 {
+			return invalidToken("bad signature")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, hash[:], r, s) {
+			return invalidToken("bad signature")
+		}
+	default:
+		return invalidToken("unsupported algorithm %q", alg)
+	}
+	return nil
+}
+
+// key finds the key for kid.
+func (v *JWTVerifier) key(ctx context.Context, kid string) (any, error) {
+	if k, ok := v.Keys[kid]; ok && kid != "" {
+		return k, nil
+	}
+	if v.JWKSURL != "" {
+		k, err := v.jwksKey(ctx, kid)
+		if err != nil || k != nil {
+			return k, err
+		}
+	}
+	if v.Key != nil {
+		return v.Key, nil
+	}
+	return nil, invalidToken("unknown key %q", kid)
+}
+
+func (v *JWTVerifier) jwksKey(ctx context.Context, kid string) (any, error) {
+	refresh := v.JWKSRefresh
+	if refresh <= 0 {
+		refresh = time.Hour
+	}
+	v.mu.Lock()
+	k := v.findJWK(kid)
+	stale := time.Since(v.jwksAt) > refresh
+	// unknown key IDs might be new keys, but don't let bad tokens make us fetch on every request
+	if !stale && (k != nil || time.Since(v.jwksTried) <= time.Minute) {
+		v.mu.Unlock()
+		return k, nil
+	}
+	if v.jwksFetch == nil {
+		// fetch without holding the lock, so other requests can use the keys we have, and any that
+		// need the new keys wait for this fetch rather than starting their own
+		done := make(chan struct{})
+		v.jwksFetch = done
+		v.jwksTried = time.Now()
+		v.mu.Unlock()
+		keys, err := v.fetchJWKS(ctx)
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		v.jwksFetch = nil
+		close(done)
+		v.jwksErr = err
+		if err != nil {
+			if v.jwks == nil {
+				return nil, err
+			}
+			L(ctx).Error().Printf("couldn't refresh JWKS from %v: %v", v.JWKSURL, err)
+		} else {
+			v.jwks = keys
+			v.jwksAt = time.Now()
+		}
+		return v.findJWK(kid), nil
+	}
+	done := v.jwksFetch
+	v.mu.Unlock()
+	if k != nil {
+		// a key from the old set is fine while it's refreshed
+		return k, nil
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.jwks == nil {
+		return nil, v.jwksErr
+	}
+	return v.findJWK(kid), nil
+}
+
+// findJWK returns the key for kid from the JWKS, or the only key if there's no kid. v.mu must be held.
+func (v *JWTVerifier) findJWK(kid string) any {
+	if k, ok := v.jwks[kid]; ok {
+		return k
+	}
+	if kid == "" && len(v.jwks) == 1 {
+		for _, k := range v.jwks {
+			return k
+		}
+	}
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// symmetric
+	K string `json:"k"`
+}
+
+func (v *JWTVerifier) fetchJWKS(ctx context.Context) (map[string]any, error) {
+	c := v.Client
+	if c == nil {
+		c = DefaultClient
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := c.GetJSON(ctx, v.JWKSURL, &set, nil); err != nil {
+		return nil, fmt.Errorf("couldn't fetch JWKS: %w", err)
+	}
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			L(ctx).Info().Printf("skipping JWKS key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (any, error) {
+	b64 := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("point not on curve")
+		}
+		return pub, nil
+	case "oct":
+		return b64.DecodeString(k.K)
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// bearerToken returns the token from the Authorization header.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Wrap returns a handler that verifies the bearer token before calling h with the claims on the context.
+func (v *JWTVerifier) Wrap(h ErrorHandlerFunc) ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		token := bearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			return NewHTTPError("missing bearer token", http.StatusUnauthorized)
+		}
+		claims, err := v.Verify(r.Context(), token)
+		if err != nil {
+			var he HTTPError
+			if errors.As(err, &he) && he.Code() == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			}
+			return err
+		}
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		if claims.Subject != "" {
+			ctx = With(ctx, "sub", claims.Subject)
+		}
+		return h(w, r.WithContext(ctx))
+	}
+}
+
+// Handler returns an http.Handler that verifies the bearer token before calling h, responding with
+// the error if it fails.
+func (v *JWTVerifier) Handler(h http.Handler) http.Handler {
+	return ErrorHandler(v.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		h.ServeHTTP(w, r)
+		return nil
+	}))
+}
@@ -0,0 +1,218 @@
+package gotils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func signTestJWT(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	b64 := base64.RawURLEncoding
+	hb, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	cb, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(hb) + "." + b64.EncodeToString(cb)
+	hash := sha256.Sum256([]byte(signed))
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, hash[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("s3cret")
+	b64 := base64.RawURLEncoding
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteObject(w, 200, map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": b64.EncodeToString(rsaKey.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64.EncodeToString(ecKey.X.Bytes()), "y": b64.EncodeToString(ecKey.Y.Bytes())},
+		}})
+	}))
+	defer jwks.Close()
+
+	v := &JWTVerifier{JWKSURL: jwks.URL, Keys: map[string]any{"hs": secret}, Issuer: "me", Audience: "api"}
+	ts := httptest.NewServer(ErrorHandler(v.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		claims := ClaimsFromContext(r.Context())
+		custom := struct {
+			Name string `json:"name"`
+		}{}
+		if err := DecodeClaims(r.Context(), &custom); err != nil {
+			return err
+		}
+		return WriteObject(w, 200, map[string]any{"sub": claims.Subject, "name": custom.Name, "field": Fields(r.Context())["sub"]})
+	})))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := NewClient(ts.URL)
+	now := time.Now().Unix()
+	good := map[string]any{"iss": "me", "aud": []string{"api", "other"}, "sub": "bob", "name": "Bob", "exp": now + 60, "scope": "read write"}
+	with := func(k string, val any) map[string]any {
+		m := map[string]any{}
+		for k, v := range good {
+			m[k] = v
+		}
+		m[k] = val
+		return m
+	}
+	call := func(token string) (map[string]any, error) {
+		out := map[string]any{}
+		err := c.GetJSON(ctx, "/", &out, &RequestOptions{Auth: BearerToken(token)})
+		return out, err
+	}
+	for _, token := range []string{
+		signTestJWT(t, "HS256", "hs", secret, good),
+		signTestJWT(t, "RS256", "rsa1", rsaKey, good),
+		signTestJWT(t, "ES256", "ec1", ecKey, good),
+		// NumericDates can be fractional
+		signTestJWT(t, "HS256", "hs", secret, with("exp", float64(now+60)+0.5)),
+	} {
+		out, err := call(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out["sub"] != "bob" || out["name"] != "Bob" || out["field"] != "bob" {
+			t.Errorf("unexpected claims %v", out)
+		}
+	}
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	for name, token := range map[string]string{
+		"expired":       signTestJWT(t, "ES256", "ec1", ecKey, with("exp", now-120)),
+		"expired frac":  signTestJWT(t, "ES256", "ec1", ecKey, with("exp", float64(now-120)+0.5)),
+		"bad exp":       signTestJWT(t, "ES256", "ec1", ecKey, with("exp", "tomorrow")),
+		"not yet":       signTestJWT(t, "ES256", "ec1", ecKey, with("nbf", now+120)),
+		"issuer":        signTestJWT(t, "ES256", "ec1", ecKey, with("iss", "them")),
+		"audience":      signTestJWT(t, "ES256", "ec1", ecKey, with("aud", "elsewhere")),
+		"wrong key":     signTestJWT(t, "ES256", "ec1", otherKey, good),
+		"unknown kid":   signTestJWT(t, "ES256", "nope", ecKey, good),
+		"alg confusion": signTestJWT(t, "HS256", "rsa1", secret, good),
+		"garbage":       "not.a.token",
+		"missing":       "",
+	} {
+		_, err := call(token)
+		var he HTTPError
+		if !errors.As(err, &he) || he.Code() != 401 {
+			t.Errorf("%v: expected 401, got %v", name, err)
+		}
+	}
+
+	// skew lets slightly expired tokens through
+	if _, err := call(signTestJWT(t, "ES256", "ec1", ecKey, with("exp", now-10))); err != nil {
+		t.Error("expected token within skew to pass, got", err)
+	}
+
+	v.Scopes = []string{"admin"}
+	_, err := call(signTestJWT(t, "HS256", "hs", secret, good))
+	var he HTTPError
+	if !errors.As(err, &he) || he.Code() != 403 {
+		t.Error("expected 403, got", err)
+	}
+}
+
+func TestNumericDate(t *testing.T) {
+	for in, want := range map[string]NumericDate{
+		`{"exp":1700000000}`:   1700000000,
+		`{"exp":1700000000.5}`: 1700000000,
+		`{"exp":1.7e9}`:        1700000000,
+	} {
+		c := &Claims{}
+		if err := json.Unmarshal([]byte(in), c); err != nil {
+			t.Fatal(in, err)
+		}
+		if c.ExpiresAt != want {
+			t.Errorf("%v: expected %v, got %v", in, want, c.ExpiresAt)
+		}
+	}
+	c := &Claims{}
+	if err := json.Unmarshal([]byte(`{"iat":1700000000.999}`), c); err != nil || c.IssuedAt.Time().Unix() != 1700000000 {
+		t.Errorf("unexpected iat %v: %v", c.IssuedAt, err)
+	}
+	if err := json.Unmarshal([]byte(`{"exp":"soon"}`), c); err == nil {
+		t.Error("expected an error for a string exp")
+	}
+}
+
+func TestJWKSFetchOnce(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b64 := base64.RawURLEncoding
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		WriteObject(w, 200, map[string]any{"keys": []map[string]string{
+			{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64.EncodeToString(ecKey.X.Bytes()), "y": b64.EncodeToString(ecKey.Y.Bytes())},
+		}})
+	}))
+	defer jwks.Close()
+	v := &JWTVerifier{JWKSURL: jwks.URL}
+	ctx := context.Background()
+
+	// requests that need the keys wait for one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if k, err := v.key(ctx, "ec1"); err != nil || k == nil {
+				t.Errorf("expected the key, got %v %v", k, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected 1 fetch, got %v", n)
+	}
+
+	// while stale keys are refreshed, the ones we have are used without waiting
+	release = make(chan struct{})
+	v.mu.Lock()
+	v.jwksAt = time.Now().Add(-2 * time.Hour)
+	v.mu.Unlock()
+	go v.key(ctx, "ec1")
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if k, err := v.key(ctx, "ec1"); err != nil || k == nil || time.Since(start) > 40*time.Millisecond {
+		t.Errorf("expected the old key without waiting, got %v %v after %v", k, err, time.Since(start))
+	}
+	close(release)
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("expected 2 fetches, got %v", n)
+	}
+}
@@ -0,0 +1,257 @@
+package gotils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// TestingT is the part of *testing.T that MockServer uses.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...any)
+	Cleanup(func())
+}
+
+// MockServer is a fake API for testing client code. Set up the calls you expect and it checks them
+// as they come in, then checks every expectation was called the right number of times when the test ends.
+//
+//	m := gotils.NewMockServer(t)
+//	m.Expect("POST", "/users").WithJSON(&User{Name: "bob"}).Respond(201, &User{ID: "1", Name: "bob"})
+//	m.Expect("GET", "/users/2").RespondError(404, gotils.ErrNotFound)
+//	c := gotils.NewClient(m.URL)
+type MockServer struct {
+	URL string
+
+	t            TestingT
+	srv          *httptest.Server
+	mu           sync.Mutex
+	expectations []*Expectation
+}
+
+// Expectation is an expected call, see MockServer.Expect.
+type Expectation struct {
+	method  string
+	path    string
+	headers map[string]string
+	body    any
+	hasBody bool
+	times   int // -1 for any
+	calls   int
+	code    int
+	resp    any
+	err     error
+	handler http.HandlerFunc
+}
+
+// NewMockServer starts a MockServer that's closed and verified when the test finishes.
+func NewMockServer(t TestingT) *MockServer {
+	t.Helper()
+	m := &MockServer{t: t}
+	m.srv = httptest.NewServer(http.HandlerFunc(m.serve))
+	m.URL = m.srv.URL
+	t.Cleanup(func() {
+		m.srv.Close()
+		m.Verify()
+	})
+	return m
+}
+
+// Expect adds an expected call to method and path. If path has a query string, the query must match too.
+// By default it's expected once and responds 200 with no body.
+func (m *MockServer) Expect(method, path string) *Expectation {
+	e := &Expectation{method: method, path: path, times: 1, code: http.StatusOK}
+	m.mu.Lock()
+	m.expectations = append(m.expectations, e)
+	m.mu.Unlock()
+	return e
+}
+
+// WithJSON expects the request body to be JSON equal to v. v can be a struct, map or anything else
+// that marshals to JSON.
+func (e *Expectation) WithJSON(v any) *Expectation {
+	e.body = v
+	e.hasBody = true
+	return e
+}
+
+// WithHeader expects the request to have the header key set to value.
+func (e *Expectation) WithHeader(key, value string) *Expectation {
+	if e.headers == nil {
+		e.headers = map[string]string{}
+	}
+	e.headers[key] = value
+	return e
+}
+
+// Times sets how many times the call is expected.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// AnyTimes allows the call any number of times, including none.
+func (e *Expectation) AnyTimes() *Expectation {
+	e.times = -1
+	return e
+}
+
+// Respond responds with code and v as JSON. v can be nil for no body.
+func (e *Expectation) Respond(code int, v any) *Expectation {
+	e.code = code
+	e.resp = v
+	return e
+}
+
+// RespondError responds with err the same way WriteError does.
+func (e *Expectation) RespondError(code int, err error) *Expectation {
+	e.code = code
+	e.err = err
+	return e
+}
+
+// RespondWith uses h to write the response, for anything Respond can't do.
+func (e *Expectation) RespondWith(h http.HandlerFunc) *Expectation {
+	e.handler = h
+	return e
+}
+
+// Verify reports any expectations that weren't called the expected number of times. It's called
+// automatically when the test finishes.
+func (m *MockServer) Verify() {
+	m.t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.expectations {
+		if e.times >= 0 && e.calls != e.times {
+			m.t.Errorf("expected %v %v to be called %v times, got %v", e.method, e.path, e.times, e.calls)
+		}
+	}
+}
+
+func (m *MockServer) serve(w http.ResponseWriter, r *http.Request) {
+	m.t.Helper()
+	body, _ := io.ReadAll(r.Body)
+	e, mismatches := m.match(r, body)
+	if e == nil {
+		msg := fmt.Sprintf("unexpected request %v %v", r.Method, r.URL.RequestURI())
+		if len(mismatches) > 0 {
+			msg += "\n" + strings.Join(mismatches, "\n")
+		}
+		m.t.Errorf("%v", msg)
+		WriteError(w, http.StatusNotImplemented, fmt.Errorf("%v", msg))
+		return
+	}
+	switch {
+	case e.handler != nil:
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		e.handler(w, r)
+	case e.err != nil:
+		WriteError(w, e.code, e.err)
+	case e.resp != nil:
+		WriteObject(w, e.code, e.resp)
+	default:
+		w.WriteHeader(e.code)
+	}
+}
+
+// match finds the first expectation that matches and still has calls left. If none do, it returns
+// why the ones for the same method and path didn't match.
+func (m *MockServer) match(r *http.Request, body []byte) (*Expectation, []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var mismatches []string
+	for _, e := range m.expectations {
+		if e.method != r.Method || !e.matchPath(r) {
+			continue
+		}
+		if e.times >= 0 && e.calls >= e.times {
+			mismatches = append(mismatches, fmt.Sprintf("already called %v times", e.calls))
+			continue
+		}
+		if why := e.mismatch(r, body); why != "" {
+			mismatches = append(mismatches, why)
+			continue
+		}
+		e.calls++
+		return e, nil
+	}
+	return nil, mismatches
+}
+
+func (e *Expectation) matchPath(r *http.Request) bool {
+	if strings.Contains(e.path, "?") {
+		return e.path == r.URL.RequestURI()
+	}
+	return e.path == r.URL.Path
+}
+
+func (e *Expectation) mismatch(r *http.Request, body []byte) string {
+	for k, v := range e.headers {
+		if got := r.Header.Get(k); got != v {
+			return fmt.Sprintf("header %v: expected %q, got %q", k, v, got)
+		}
+	}
+	if !e.hasBody {
+		return ""
+	}
+	want, err := toPatchJSON(e.body)
+	if err != nil {
+		return fmt.Sprintf("couldn't marshal expected body: %v", err)
+	}
+	got, err := decodePatchJSON(body)
+	if err != nil {
+		return fmt.Sprintf("body isn't valid JSON: %v\n%s", err, body)
+	}
+	if jsonEqual(want, got) {
+		return ""
+	}
+	return "body mismatch (-expected +got):\n" + diffLines(indentJSON(want), indentJSON(got))
+}
+
+func indentJSON(v any) []string {
+	b, _ := json.MarshalIndent(v, "", "  ")
+	return strings.Split(string(b), "\n")
+}
+
+// diffLines returns a unified style line diff of a and b, with the lines only in a prefixed by "-",
+// the lines only in b by "+" and common lines by " ".
+func diffLines(a, b []string) string {
+	// longest common subsequence table
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	sb := &strings.Builder{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(sb, "  %v\n", a[i])
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			fmt.Fprintf(sb, "+ %v\n", b[j])
+			j++
+		default:
+			fmt.Fprintf(sb, "- %v\n", a[i])
+			i++
+		}
+	}
+	return sb.String()
+}
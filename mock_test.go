@@ -0,0 +1,66 @@
+package gotils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type fakeT struct {
+	errs     []string
+	cleanups []func()
+}
+
+func (f *fakeT) Helper() {}
+func (f *fakeT) Errorf(format string, args ...any) {
+	f.errs = append(f.errs, fmt.Sprintf(format, args...))
+}
+func (f *fakeT) Cleanup(fn func()) { f.cleanups = append(f.cleanups, fn) }
+
+func TestMockServer(t *testing.T) {
+	ctx := context.Background()
+	m := NewMockServer(t)
+	m.Expect("POST", "/users").WithJSON(map[string]any{"name": "bob"}).Respond(201, &clientThing{Name: "bob"})
+	m.Expect("GET", "/users/2").RespondError(404, ErrNotFound).Times(2)
+	c := NewClient(m.URL)
+
+	out := &clientThing{}
+	if err := c.PostJSON(ctx, "/users", &clientThing{Name: "bob"}, out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "bob" {
+		t.Error("unexpected response", out)
+	}
+	for i := 0; i < 2; i++ {
+		var he HTTPError
+		err := c.GetJSON(ctx, "/users/2", out, nil)
+		if !errors.As(err, &he) || he.Code() != 404 {
+			t.Error("expected 404, got", err)
+		}
+	}
+
+	// failures are reported to the test
+	ft := &fakeT{}
+	m2 := NewMockServer(ft)
+	m2.Expect("POST", "/users").WithJSON(map[string]any{"name": "bob", "age": 3}).Respond(201, nil)
+	m2.Expect("DELETE", "/users/1")
+	c = NewClient(m2.URL)
+	err := c.PostJSON(ctx, "/users", map[string]any{"name": "alice", "age": 3}, nil, nil)
+	if err == nil {
+		t.Error("expected an error for a mismatched body")
+	}
+	for _, fn := range ft.cleanups {
+		fn()
+	}
+	if len(ft.errs) != 3 {
+		t.Fatalf("expected 3 errors, got %q", ft.errs)
+	}
+	if !strings.Contains(ft.errs[0], `-   "name": "bob"`) || !strings.Contains(ft.errs[0], `+   "name": "alice"`) {
+		t.Error("expected a diff, got", ft.errs[0])
+	}
+	if !strings.Contains(ft.errs[2], "DELETE /users/1 to be called 1 times, got 0") {
+		t.Error("unexpected error", ft.errs[2])
+	}
+}
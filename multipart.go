@@ -0,0 +1,201 @@
+package gotils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FormFile is a file to upload with PostMultipart. Set either Reader or Path.
+type FormFile struct {
+	// Field is the form field name.
+	Field string
+	// Filename defaults to the base name of Path.
+	Filename string
+	// ContentType of the part, defaults to application/octet-stream.
+	ContentType string
+	// Reader to read the file contents from.
+	Reader io.Reader
+	// Path of a file to read the contents from. It's opened when the upload starts.
+	Path string
+	// Size of the contents, only used for progress reporting. It's figured out automatically if
+	// Path is used or Reader is an *os.File, *bytes.Reader, *strings.Reader or *bytes.Buffer.
+	Size int64
+}
+
+func (f *FormFile) filename() string {
+	if f.Filename != "" {
+		return f.Filename
+	}
+	if f.Path != "" {
+		return filepath.Base(f.Path)
+	}
+	return f.Field
+}
+
+func (f *FormFile) header() textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(f.Field), escapeQuotes(f.filename())))
+	h.Set("Content-Type", OrString(f.ContentType, "application/octet-stream"))
+	return h
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// size returns the size of the file contents or -1 if unknown.
+func (f *FormFile) size() int64 {
+	if f.Size > 0 {
+		return f.Size
+	}
+	if f.Path != "" {
+		if fi, err := os.Stat(f.Path); err == nil {
+			return fi.Size()
+		}
+		return -1
+	}
+	switch r := f.Reader.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case interface{ Stat() (fs.FileInfo, error) }:
+		if fi, err := r.Stat(); err == nil {
+			return fi.Size()
+		}
+	}
+	return -1
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// multipartSize works out the exact size of the body by writing everything but the file contents.
+// Returns -1 if any file size is unknown.
+func multipartSize(boundary string, fields map[string]string, files []*FormFile) int64 {
+	cw := &countWriter{}
+	mp := multipart.NewWriter(cw)
+	mp.SetBoundary(boundary)
+	for _, k := range sortedKeys(fields) {
+		mp.WriteField(k, fields[k])
+	}
+	for _, f := range files {
+		size := f.size()
+		if size < 0 {
+			return -1
+		}
+		mp.CreatePart(f.header())
+		cw.n += size
+	}
+	mp.Close()
+	return cw.n
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// uploadBody is the streaming request body. It reports progress and can't be replayed, so the
+// client won't try to buffer it for retries.
+type uploadBody struct {
+	r        io.Reader
+	sent     int64
+	total    int64
+	progress func(sent, total int64)
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if n > 0 && b.progress != nil {
+		b.sent += int64(n)
+		b.progress(b.sent, b.total)
+	}
+	return n, err
+}
+
+func (b *uploadBody) streaming() {}
+
+// PostMultipart uploads fields and files as multipart/form-data. The body is streamed so large
+// files are never held in memory. Set RequestOptions.UploadProgress to track the upload.
+//
+// Since the body is streamed, the request isn't retried even if a RetryPolicy is set.
+//
+//	err := c.PostMultipart(ctx, url, map[string]string{"name": "cat"}, []*gotils.FormFile{
+//		{Field: "photo", Path: "cat.jpg", ContentType: "image/jpeg"},
+//	}, out, nil)
+func (c *Client) PostMultipart(ctx context.Context, url string, fields map[string]string, files []*FormFile, tout any, opts *RequestOptions) error {
+	pr, pw := io.Pipe()
+	mp := multipart.NewWriter(pw)
+	body := &uploadBody{r: pr, total: -1}
+	if opts != nil && opts.UploadProgress != nil {
+		body.progress = opts.UploadProgress
+		body.total = multipartSize(mp.Boundary(), fields, files)
+	}
+	go func() {
+		pw.CloseWithError(writeMultipart(mp, fields, files))
+	}()
+	err := c.Do(ctx, url, http.MethodPost, body, tout, withHeader(opts, "Content-Type", mp.FormDataContentType()))
+	// unblocks the writer if the request ended early
+	pr.Close()
+	return err
+}
+
+func writeMultipart(mp *multipart.Writer, fields map[string]string, files []*FormFile) error {
+	for _, k := range sortedKeys(fields) {
+		if err := mp.WriteField(k, fields[k]); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		if err := writeFormFile(mp, f); err != nil {
+			return err
+		}
+	}
+	return mp.Close()
+}
+
+func writeFormFile(mp *multipart.Writer, f *FormFile) error {
+	r := f.Reader
+	if r == nil {
+		if f.Path == "" {
+			return fmt.Errorf("FormFile %q needs a Reader or Path", f.Field)
+		}
+		file, err := os.Open(f.Path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	part, err := mp.CreatePart(f.header())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, r)
+	return err
+}
+
+// PostMultipart uploads fields and files as multipart/form-data using the DefaultClient. See Client.PostMultipart.
+func PostMultipart(ctx context.Context, url string, fields map[string]string, files []*FormFile, tout any, opts *RequestOptions) error {
+	return DefaultClient.PostMultipart(ctx, url, fields, files, tout, opts)
+}
@@ -0,0 +1,65 @@
+package gotils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPostMultipart(t *testing.T) {
+	ts := httptest.NewServer(ErrorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		err := r.ParseMultipartForm(1 << 20)
+		if err != nil {
+			return err
+		}
+		out := map[string]string{}
+		for k, v := range r.MultipartForm.Value {
+			out[k] = v[0]
+		}
+		for k, fhs := range r.MultipartForm.File {
+			f, _ := fhs[0].Open()
+			b, _ := io.ReadAll(f)
+			f.Close()
+			out[k] = fhs[0].Filename + ":" + fhs[0].Header.Get("Content-Type") + ":" + string(b)
+		}
+		return WriteObject(w, 200, out)
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "hello.txt")
+	os.WriteFile(path, []byte("hello from disk"), 0644)
+
+	ctx := context.Background()
+	var sent, total int64
+	out := map[string]string{}
+	err := PostMultipart(ctx, ts.URL, map[string]string{"name": "bob"}, []*FormFile{
+		{Field: "a", Filename: "a.txt", ContentType: "text/plain", Reader: strings.NewReader("hello from memory")},
+		{Field: "b", Path: path},
+	}, &out, &RequestOptions{UploadProgress: func(s, t int64) {
+		sent, total = s, t
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["name"] != "bob" || out["a"] != "a.txt:text/plain:hello from memory" || out["b"] != "hello.txt:application/octet-stream:hello from disk" {
+		t.Errorf("unexpected form %v", out)
+	}
+	if total <= 0 || sent != total {
+		t.Errorf("expected progress to reach the total, got %v of %v", sent, total)
+	}
+
+	// used to panic with nil options
+	out = map[string]string{}
+	err = PostMultipartForm(ctx, ts.URL, map[string]string{"x": "y"}, &out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["x"] != "y" {
+		t.Errorf("unexpected form %v", out)
+	}
+}
@@ -0,0 +1,387 @@
+package gotils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	urlp "net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrStopPaging can be returned from a Pager callback to stop early without an error.
+var ErrStopPaging = errors.New("stop paging")
+
+// PageStrategy works out the URL of each page for a Pager.
+type PageStrategy interface {
+	// First returns the URL of the first page.
+	First(url string) (string, error)
+	// Next returns the URL of the page after the one fetched from url, or "" if that was the last page.
+	// items is the number of items on the page.
+	Next(url string, header http.Header, body []byte, items int) (string, error)
+}
+
+// Page is one page fetched by a Pager.
+type Page[T any] struct {
+	URL    string
+	Header http.Header
+	// Body is the raw response body.
+	Body  []byte
+	Items []T
+}
+
+// Pager iterates over the items of a paged API, fetching pages with GET requests as needed.
+//
+//	p := gotils.NewPager[*User](c, "/users", gotils.LinkNext())
+//	err := p.Each(ctx, func(u *User) error {
+//		fmt.Println(u.Name)
+//		return nil
+//	})
+type Pager[T any] struct {
+	Client   *Client
+	URL      string
+	Strategy PageStrategy
+	// ItemsField is the dotted path of the items array in the response, such as "data" or
+	// "result.items". Empty means the response is the array.
+	ItemsField string
+	// Prefetch is how many pages to fetch ahead while the current page is being processed. 0 waits
+	// for each page to be processed before fetching the next one, as does a negative value.
+	Prefetch int
+	// Options are used for every request.
+	Options *RequestOptions
+}
+
+// NewPager returns a Pager for url using strategy. c can be nil to use the DefaultClient.
+func NewPager[T any](c *Client, url string, strategy PageStrategy) *Pager[T] {
+	return &Pager[T]{Client: c, URL: url, Strategy: strategy}
+}
+
+// Each calls fn for every item on every page. Return ErrStopPaging from fn to stop early.
+func (p *Pager[T]) Each(ctx context.Context, fn func(T) error) error {
+	return p.EachPage(ctx, func(page *Page[T]) error {
+		for _, item := range page.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// All returns the items from every page.
+func (p *Pager[T]) All(ctx context.Context) ([]T, error) {
+	var all []T
+	err := p.EachPage(ctx, func(page *Page[T]) error {
+		all = append(all, page.Items...)
+		return nil
+	})
+	return all, err
+}
+
+// EachPage calls fn for every page. Return ErrStopPaging from fn to stop early.
+func (p *Pager[T]) EachPage(ctx context.Context, fn func(*Page[T]) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	prefetch := p.Prefetch
+	if prefetch < 0 {
+		prefetch = 0
+	}
+	pages := make(chan *Page[T], prefetch)
+	// a token is taken for each page fetched and returned once fn is done with one, so fetching is
+	// at most Prefetch pages ahead
+	tokens := make(chan struct{}, prefetch+1)
+	for i := 0; i <= prefetch; i++ {
+		tokens <- struct{}{}
+	}
+	errc := make(chan error, 1)
+	go func() {
+		defer close(pages)
+		errc <- p.fetchAll(ctx, pages, tokens)
+	}()
+	for page := range pages {
+		if err := fn(page); err != nil {
+			if errors.Is(err, ErrStopPaging) {
+				return nil
+			}
+			return err
+		}
+		tokens <- struct{}{}
+	}
+	return <-errc
+}
+
+func (p *Pager[T]) fetchAll(ctx context.Context, pages chan<- *Page[T], tokens <-chan struct{}) error {
+	url, err := p.Strategy.First(p.client().url(p.URL))
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for url != "" {
+		if seen[url] {
+			// the API sent us back to a page we've had, which would loop forever
+			L(ctx).Info().Printf("stopped paging at %v, it was already fetched", url)
+			return nil
+		}
+		seen[url] = true
+		select {
+		case <-tokens:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		page, err := p.fetch(ctx, url)
+		if err != nil {
+			return err
+		}
+		select {
+		case pages <- page:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		url, err = p.Strategy.Next(url, page.Header, page.Body, len(page.Items))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Pager[T]) client() *Client {
+	if p.Client == nil {
+		return DefaultClient
+	}
+	return p.Client
+}
+
+func (p *Pager[T]) fetch(ctx context.Context, url string) (*Page[T], error) {
+	resp, err := p.client().send(ctx, url, http.MethodGet, nil, p.Options)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, C(ctx).Errorf("ReadAll: %w", err)
+	}
+	page := &Page[T]{URL: url, Header: resp.Header, Body: body}
+	raw, err := jsonField(body, p.ItemsField)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > 0 && !bytes.Equal(raw, []byte("null")) {
+		if err := json.Unmarshal(raw, &page.Items); err != nil {
+			return nil, C(ctx).Errorf("couldn't parse items from %v: %w", url, err)
+		}
+	}
+	return page, nil
+}
+
+// jsonField returns the raw JSON at the dotted path in body. A missing field returns nil.
+func jsonField(body []byte, path string) (json.RawMessage, error) {
+	raw := json.RawMessage(body)
+	if path == "" {
+		return raw, nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+			return nil, nil
+		}
+		m := map[string]json.RawMessage{}
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, fmt.Errorf("couldn't read %q from response: %w", path, err)
+		}
+		raw = m[key]
+	}
+	return raw, nil
+}
+
+// LinkNext follows the Link header with rel="next", as used by GitHub and others.
+func LinkNext() PageStrategy {
+	return linkNext{}
+}
+
+type linkNext struct{}
+
+func (linkNext) First(url string) (string, error) {
+	return url, nil
+}
+
+func (linkNext) Next(url string, header http.Header, body []byte, items int) (string, error) {
+	for _, v := range header.Values("Link") {
+		for _, link := range strings.Split(v, ",") {
+			target, params, ok := strings.Cut(link, ";")
+			if !ok {
+				continue
+			}
+			target = strings.Trim(strings.TrimSpace(target), "<>")
+			for _, param := range strings.Split(params, ";") {
+				k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if k != "rel" {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(v, `"`)) {
+					if rel == "next" {
+						return resolveURL(url, target)
+					}
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+func resolveURL(base, ref string) (string, error) {
+	b, err := urlp.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := urlp.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+// setQuery returns url with the query parameter key set to value.
+func setQuery(url, key, value string) (string, error) {
+	u, err := urlp.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Cursor pages by reading the next cursor from Field, a dotted path in the response body such as
+// "meta.next_cursor", and sending it in the query parameter Param. Paging stops when the cursor is
+// missing, null or empty.
+type Cursor struct {
+	Field string
+	Param string
+}
+
+// First returns url unchanged.
+func (s *Cursor) First(url string) (string, error) {
+	return url, nil
+}
+
+// Next returns url with the cursor from body.
+func (s *Cursor) Next(url string, header http.Header, body []byte, items int) (string, error) {
+	raw, err := jsonField(body, s.Field)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return "", nil
+	}
+	var cursor string
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		// numeric cursors
+		cursor = string(raw)
+	}
+	if cursor == "" {
+		return "", nil
+	}
+	return setQuery(url, s.Param, cursor)
+}
+
+// OffsetLimit pages with offset and limit query parameters, stopping at the first page with fewer
+// than Limit items.
+type OffsetLimit struct {
+	// OffsetParam defaults to "offset".
+	OffsetParam string
+	// LimitParam defaults to "limit".
+	LimitParam string
+	Limit      int
+}
+
+func (s *OffsetLimit) params() (string, string) {
+	offset, limit := s.OffsetParam, s.LimitParam
+	if offset == "" {
+		offset = "offset"
+	}
+	if limit == "" {
+		limit = "limit"
+	}
+	return offset, limit
+}
+
+// First returns url with an offset of 0.
+func (s *OffsetLimit) First(url string) (string, error) {
+	if s.Limit <= 0 {
+		return "", errors.New("OffsetLimit needs a Limit")
+	}
+	offset, limit := s.params()
+	url, err := setQuery(url, offset, "0")
+	if err != nil {
+		return "", err
+	}
+	return setQuery(url, limit, strconv.Itoa(s.Limit))
+}
+
+// Next returns url with the offset moved on by Limit.
+func (s *OffsetLimit) Next(url string, header http.Header, body []byte, items int) (string, error) {
+	if items < s.Limit {
+		return "", nil
+	}
+	offsetParam, _ := s.params()
+	u, err := urlp.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	offset, _ := strconv.Atoi(u.Query().Get(offsetParam))
+	return setQuery(url, offsetParam, strconv.Itoa(offset+s.Limit))
+}
+
+// PageNumber pages with a page number query parameter, stopping at an empty page, or with Size set,
+// at the first page with fewer than Size items.
+type PageNumber struct {
+	// Param defaults to "page".
+	Param string
+	// Start is the first page number, 1 if unset. Point it at 0 for APIs that start at 0.
+	Start *int
+	// SizeParam is the query parameter for the page size, sent if set along with Size.
+	SizeParam string
+	Size      int
+}
+
+func (s *PageNumber) param() string {
+	if s.Param == "" {
+		return "page"
+	}
+	return s.Param
+}
+
+// First returns url with the first page number.
+func (s *PageNumber) First(url string) (string, error) {
+	start := 1
+	if s.Start != nil {
+		start = *s.Start
+	}
+	url, err := setQuery(url, s.param(), strconv.Itoa(start))
+	if err != nil {
+		return "", err
+	}
+	if s.SizeParam != "" && s.Size > 0 {
+		return setQuery(url, s.SizeParam, strconv.Itoa(s.Size))
+	}
+	return url, nil
+}
+
+// Next returns url with the next page number.
+func (s *PageNumber) Next(url string, header http.Header, body []byte, items int) (string, error) {
+	if items == 0 || (s.Size > 0 && items < s.Size) {
+		return "", nil
+	}
+	u, err := urlp.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	page, _ := strconv.Atoi(u.Query().Get(s.param()))
+	return setQuery(url, s.param(), strconv.Itoa(page+1))
+}
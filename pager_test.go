@@ -0,0 +1,139 @@
+package gotils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPager(t *testing.T) {
+	items := make([]int, 25)
+	for i := range items {
+		items[i] = i
+	}
+	slice := func(from, n int) []int {
+		if from > len(items) {
+			from = len(items)
+		}
+		to := from + n
+		if to > len(items) {
+			to = len(items)
+		}
+		return items[from:to]
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/link":
+			page, _ := strconv.Atoi(q.Get("p"))
+			if (page+1)*10 < len(items) {
+				w.Header().Set("Link", fmt.Sprintf(`</link?p=%d>; rel="next", </link?p=0>; rel="first"`, page+1))
+			}
+			WriteObject(w, 200, slice(page*10, 10))
+		case "/cursor":
+			from, _ := strconv.Atoi(q.Get("after"))
+			next := ""
+			if from+10 < len(items) {
+				next = strconv.Itoa(from + 10)
+			}
+			WriteObject(w, 200, map[string]any{"data": slice(from, 10), "meta": map[string]any{"next": next}})
+		case "/offset":
+			offset, _ := strconv.Atoi(q.Get("offset"))
+			limit, _ := strconv.Atoi(q.Get("limit"))
+			WriteObject(w, 200, map[string]any{"items": slice(offset, limit)})
+		case "/page":
+			page, _ := strconv.Atoi(q.Get("page"))
+			WriteObject(w, 200, slice((page-1)*10, 10))
+		case "/page0":
+			page, _ := strconv.Atoi(q.Get("page"))
+			WriteObject(w, 200, slice(page*10, 10))
+		case "/loop":
+			// a broken API that keeps pointing at the same page
+			w.Header().Set("Link", `</loop>; rel="next"`)
+			WriteObject(w, 200, items)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := NewClient(ts.URL)
+	zero := 0
+	tests := []struct {
+		name  string
+		pager *Pager[int]
+	}{
+		{"link", NewPager[int](c, "/link", LinkNext())},
+		{"cursor", &Pager[int]{Client: c, URL: "/cursor", Strategy: &Cursor{Field: "meta.next", Param: "after"}, ItemsField: "data", Prefetch: 2}},
+		{"offset", &Pager[int]{Client: c, URL: "/offset", Strategy: &OffsetLimit{Limit: 5}, ItemsField: "items"}},
+		{"page", NewPager[int](c, "/page", &PageNumber{})},
+		{"page0", NewPager[int](c, "/page0", &PageNumber{Start: &zero})},
+		{"loop", NewPager[int](c, "/loop", LinkNext())},
+	}
+	for _, test := range tests {
+		all, err := test.pager.All(ctx)
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		if fmt.Sprint(all) != fmt.Sprint(items) {
+			t.Errorf("%v: expected %v, got %v", test.name, items, all)
+		}
+	}
+
+	// stopping early
+	n := 0
+	err := tests[1].pager.Each(ctx, func(i int) error {
+		n++
+		if i == 12 {
+			return ErrStopPaging
+		}
+		return nil
+	})
+	if err != nil || n != 13 {
+		t.Errorf("expected to stop after 13 items, got %v: %v", n, err)
+	}
+
+	// without Prefetch, the next page isn't fetched until the current one is done
+	var mu sync.Mutex
+	var fetched, done int
+	c2 := &Client{BaseURL: ts.URL, HTTPClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		mu.Lock()
+		fetched++
+		if fetched > done+1 {
+			t.Errorf("fetched page %v before page %v was done", fetched, done+1)
+		}
+		mu.Unlock()
+		return http.DefaultTransport.RoundTrip(r)
+	})}}
+	// a negative Prefetch is the same as none
+	err = (&Pager[int]{Client: c2, URL: "/page", Strategy: &PageNumber{}, Prefetch: -1}).EachPage(ctx, func(p *Page[int]) error {
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		done++
+		mu.Unlock()
+		return nil
+	})
+	if err != nil || done != 4 {
+		t.Errorf("expected 4 pages, got %v: %v", done, err)
+	}
+
+	ctx2, cancel := context.WithCancel(ctx)
+	err = tests[0].pager.EachPage(ctx2, func(p *Page[int]) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Error("expected context.Canceled, got", err)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
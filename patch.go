@@ -0,0 +1,638 @@
+package gotils
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ContentTypeMergePatch is a JSON Merge Patch, RFC 7396.
+	ContentTypeMergePatch = "application/merge-patch+json"
+	// ContentTypeJSONPatch is a JSON Patch, RFC 6902.
+	ContentTypeJSONPatch = "application/json-patch+json"
+)
+
+// PatchOp is a single JSON Patch (RFC 6902) operation.
+type PatchOp struct {
+	// Op is one of add, remove, replace, move, copy or test.
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	// From is used by move and copy.
+	From string `json:"from,omitempty"`
+	// Value is used by add, replace and test. It's kept as raw JSON so numbers don't lose precision.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// MarshalJSON always includes the value for the ops that need it, even if it's nil.
+func (op PatchOp) MarshalJSON() ([]byte, error) {
+	m := map[string]any{"op": op.Op, "path": op.Path}
+	switch op.Op {
+	case "add", "replace", "test":
+		m["value"] = op.Value
+	case "move", "copy":
+		m["from"] = op.From
+	}
+	return json.Marshal(m)
+}
+
+// PatchMerge sends patch as a JSON Merge Patch (RFC 7396) and parses the response into tout.
+// patch can be anything that marshals to JSON, or the raw bytes from CreateMergePatch.
+func (c *Client) PatchMerge(ctx context.Context, url string, patch, tout any, opts *RequestOptions) error {
+	var body []byte
+	switch p := patch.(type) {
+	case []byte:
+		body = p
+	default:
+		var err error
+		body, err = json.Marshal(patch)
+		if err != nil {
+			return C(ctx).Error(err)
+		}
+	}
+	return c.Do(ctx, url, http.MethodPatch, bytes.NewReader(body), tout, withHeader(opts, "Content-Type", ContentTypeMergePatch))
+}
+
+// PatchOps sends ops as a JSON Patch (RFC 6902) and parses the response into tout.
+func (c *Client) PatchOps(ctx context.Context, url string, ops []PatchOp, tout any, opts *RequestOptions) error {
+	body, err := json.Marshal(ops)
+	if err != nil {
+		return C(ctx).Error(err)
+	}
+	return c.Do(ctx, url, http.MethodPatch, bytes.NewReader(body), tout, withHeader(opts, "Content-Type", ContentTypeJSONPatch))
+}
+
+// PatchMerge sends patch as a JSON Merge Patch (RFC 7396) and parses the response into tout.
+//
+//	patch, err := gotils.CreateMergePatch(before, after)
+//	err = gotils.PatchMerge(ctx, url, patch, after, nil)
+func PatchMerge(ctx context.Context, url string, patch, tout any, opts *RequestOptions) error {
+	return DefaultClient.PatchMerge(ctx, url, patch, tout, opts)
+}
+
+// PatchOps sends ops as a JSON Patch (RFC 6902) and parses the response into tout.
+func PatchOps(ctx context.Context, url string, ops []PatchOp, tout any, opts *RequestOptions) error {
+	return DefaultClient.PatchOps(ctx, url, ops, tout, opts)
+}
+
+// MaxPatchSize is the largest patch ParsePatch reads, after decompressing.
+var MaxPatchSize int64 = 10 << 20
+
+// ParsePatch applies the patch in the request body to v, which should hold the current state of
+// the object, for example one you just loaded from the database. The Content-Type picks the
+// format: application/json-patch+json is a JSON Patch, application/merge-patch+json or
+// application/json is a JSON Merge Patch. gzip and deflate encoded bodies are decoded.
+//
+// Errors are HTTPErrors: 415 for an unknown Content-Type, 400 for a malformed patch, 409 if a test
+// operation fails, 413 if it's bigger than MaxPatchSize and 422 if the patch can't be applied to v.
+func ParsePatch(w http.ResponseWriter, r *http.Request, v any) error {
+	if err := decompressRequest(r, MaxPatchSize); err != nil {
+		return err
+	}
+	body, err := io.ReadAll(&maxSizeReader{r: io.LimitReader(r.Body, MaxPatchSize+1), max: MaxPatchSize, msg: "request body too large"})
+	var he HTTPError
+	if errors.As(err, &he) {
+		return err
+	}
+	if err != nil {
+		return C(r.Context()).Errorf("couldn't read patch: %w", err)
+	}
+	return ApplyPatch(v, r.Header.Get("Content-Type"), body)
+}
+
+// ApplyPatch applies a JSON Patch or JSON Merge Patch, depending on contentType, to the struct
+// (or map) pointed to by v. See ParsePatch.
+func ApplyPatch(v any, contentType string, patch []byte) error {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	var apply func(doc []byte) ([]byte, error)
+	switch mt {
+	case ContentTypeJSONPatch:
+		ops := []PatchOp{}
+		if err := json.Unmarshal(patch, &ops); err != nil {
+			return NewHTTPError(fmt.Sprintf("invalid JSON Patch: %v", err), http.StatusBadRequest)
+		}
+		apply = func(doc []byte) ([]byte, error) { return ApplyJSONPatch(doc, ops) }
+	case ContentTypeMergePatch, "application/json", "":
+		apply = func(doc []byte) ([]byte, error) { return ApplyMergePatch(doc, patch) }
+	default:
+		return NewHTTPError(fmt.Sprintf("unsupported patch content type %q", contentType), http.StatusUnsupportedMediaType)
+	}
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	doc, err = apply(doc)
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("ApplyPatch requires a non-nil pointer, got %T", v)
+	}
+	// decode into a copy with the JSON fields zeroed, so removed fields are zeroed but json:"-" and
+	// unexported fields, like a password hash loaded from the database, are kept
+	result := reflect.New(rv.Elem().Type())
+	if rv.Elem().Kind() == reflect.Struct && !decodesItself(rv.Elem().Type()) {
+		result.Elem().Set(rv.Elem())
+		zeroJSONFields(result.Elem())
+	}
+	if err := json.Unmarshal(doc, result.Interface()); err != nil {
+		return NewHTTPError(fmt.Sprintf("patch result is invalid: %v", err), http.StatusUnprocessableEntity)
+	}
+	rv.Elem().Set(result.Elem())
+	return nil
+}
+
+// zeroJSONFields zeroes the fields of struct v that encoding/json decodes into: exported fields,
+// and those of embedded structs, that aren't tagged json:"-". Nested structs keep their hidden
+// fields too, unless they decode themselves.
+func zeroJSONFields(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := v.Field(i)
+		if f.Tag.Get("json") == "-" || !fv.CanSet() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch {
+		case fv.Kind() == reflect.Struct && !decodesItself(f.Type):
+			zeroJSONFields(fv)
+		case f.Anonymous && name == "" && fv.Kind() == reflect.Pointer && !fv.IsNil() &&
+			f.Type.Elem().Kind() == reflect.Struct && !decodesItself(f.Type.Elem()):
+			// an embedded pointer's fields are promoted, so copy it rather than change the original
+			cp := reflect.New(f.Type.Elem())
+			cp.Elem().Set(fv.Elem())
+			zeroJSONFields(cp.Elem())
+			fv.Set(cp)
+		default:
+			fv.Set(reflect.Zero(f.Type))
+		}
+	}
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func decodesItself(t reflect.Type) bool {
+	pt := reflect.PointerTo(t)
+	return pt.Implements(jsonUnmarshalerType) || pt.Implements(textUnmarshalerType)
+}
+
+func decodePatchJSON(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func toPatchJSON(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodePatchJSON(b)
+}
+
+// ApplyMergePatch applies a JSON Merge Patch (RFC 7396) to the JSON document doc.
+func ApplyMergePatch(doc, patch []byte) ([]byte, error) {
+	d, err := decodePatchJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodePatchJSON(patch)
+	if err != nil {
+		return nil, NewHTTPError(fmt.Sprintf("invalid merge patch: %v", err), http.StatusBadRequest)
+	}
+	return json.Marshal(mergePatch(d, p))
+}
+
+func mergePatch(target, patch any) any {
+	pm, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	tm, ok := target.(map[string]any)
+	if !ok {
+		tm = map[string]any{}
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(tm, k)
+			continue
+		}
+		tm[k] = mergePatch(tm[k], v)
+	}
+	return tm
+}
+
+// CreateMergePatch returns a JSON Merge Patch (RFC 7396) that turns original into modified.
+// Note that merge patches can't set a value to null, it means delete, and arrays are always replaced whole.
+func CreateMergePatch(original, modified any) ([]byte, error) {
+	o, err := toPatchJSON(original)
+	if err != nil {
+		return nil, err
+	}
+	m, err := toPatchJSON(modified)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeDiff(o, m))
+}
+
+func mergeDiff(original, modified any) any {
+	om, ok1 := original.(map[string]any)
+	mm, ok2 := modified.(map[string]any)
+	if !ok1 || !ok2 {
+		return modified
+	}
+	patch := map[string]any{}
+	for k := range om {
+		if _, ok := mm[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	for k, v := range mm {
+		ov, ok := om[k]
+		if !ok {
+			patch[k] = v
+			continue
+		}
+		if jsonEqual(ov, v) {
+			continue
+		}
+		patch[k] = mergeDiff(ov, v)
+	}
+	return patch
+}
+
+// CreateJSONPatch returns the JSON Patch (RFC 6902) operations that turn original into modified.
+// Arrays that differ are replaced whole.
+func CreateJSONPatch(original, modified any) ([]PatchOp, error) {
+	o, err := toPatchJSON(original)
+	if err != nil {
+		return nil, err
+	}
+	m, err := toPatchJSON(modified)
+	if err != nil {
+		return nil, err
+	}
+	ops := []PatchOp{}
+	jsonPatchDiff(&ops, "", o, m)
+	return ops, nil
+}
+
+func jsonPatchDiff(ops *[]PatchOp, path string, original, modified any) {
+	if jsonEqual(original, modified) {
+		return
+	}
+	om, ok1 := original.(map[string]any)
+	mm, ok2 := modified.(map[string]any)
+	if !ok1 || !ok2 {
+		*ops = append(*ops, PatchOp{Op: "replace", Path: path, Value: rawJSON(modified)})
+		return
+	}
+	keys := make([]string, 0, len(om)+len(mm))
+	for k := range om {
+		keys = append(keys, k)
+	}
+	for k := range mm {
+		if _, ok := om[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := path + "/" + escapePointer(k)
+		ov, inO := om[k]
+		mv, inM := mm[k]
+		switch {
+		case !inM:
+			*ops = append(*ops, PatchOp{Op: "remove", Path: p})
+		case !inO:
+			*ops = append(*ops, PatchOp{Op: "add", Path: p, Value: rawJSON(mv)})
+		default:
+			jsonPatchDiff(ops, p, ov, mv)
+		}
+	}
+}
+
+// rawJSON marshals a decoded JSON value, which can't fail.
+func rawJSON(v any) json.RawMessage {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+// ApplyJSONPatch applies JSON Patch (RFC 6902) operations to the JSON document doc.
+// If a test operation fails, the error is an HTTPError with code 409.
+func ApplyJSONPatch(doc []byte, ops []PatchOp) ([]byte, error) {
+	d, err := decodePatchJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		d, err = applyOp(d, op)
+		if err != nil {
+			var he HTTPError
+			if errors.As(err, &he) {
+				return nil, err
+			}
+			return nil, NewHTTPError(fmt.Sprintf("patch operation %v (%v %v) failed: %v", i, op.Op, op.Path, err), http.StatusUnprocessableEntity)
+		}
+	}
+	return json.Marshal(d)
+}
+
+func applyOp(doc any, op PatchOp) (any, error) {
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	if len(op.Value) > 0 {
+		value, err = decodePatchJSON(op.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch op.Op {
+	case "add":
+		return pointerAdd(doc, tokens, value)
+	case "remove":
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("can't remove the whole document")
+		}
+		return pointerUpdate(doc, tokens, func(container any, key string) (any, error) {
+			return containerRemove(container, key)
+		})
+	case "replace":
+		if len(tokens) == 0 {
+			return value, nil
+		}
+		return pointerUpdate(doc, tokens, func(container any, key string) (any, error) {
+			if _, err := containerGet(container, key); err != nil {
+				return nil, err
+			}
+			return containerSet(container, key, value, false)
+		})
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		v, err := pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path+"/", op.From+"/") {
+				if op.Path == op.From {
+					return doc, nil
+				}
+				return nil, fmt.Errorf("can't move %v into one of its children", op.From)
+			}
+			doc, err = pointerUpdate(doc, from, func(container any, key string) (any, error) {
+				return containerRemove(container, key)
+			})
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			v = deepCopyJSON(v)
+		}
+		return pointerAdd(doc, tokens, v)
+	case "test":
+		v, err := pointerGet(doc, tokens)
+		if err != nil || !jsonEqual(v, value) {
+			return nil, NewHTTPError(fmt.Sprintf("patch test failed at %q", op.Path), http.StatusConflict)
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+func pointerAdd(doc any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(doc, tokens, func(container any, key string) (any, error) {
+		return containerSet(container, key, value, true)
+	})
+}
+
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+// pointerUpdate walks down to the parent of the last token and replaces it with what leaf returns.
+func pointerUpdate(node any, tokens []string, leaf func(container any, key string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return leaf(node, tokens[0])
+	}
+	child, err := containerGet(node, tokens[0])
+	if err != nil {
+		return nil, err
+	}
+	child, err = pointerUpdate(child, tokens[1:], leaf)
+	if err != nil {
+		return nil, err
+	}
+	return containerSet(node, tokens[0], child, false)
+}
+
+func pointerGet(node any, tokens []string) (any, error) {
+	for _, t := range tokens {
+		var err error
+		node, err = containerGet(node, t)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func arrayIndex(key string, n int, insert bool) (int, error) {
+	if insert && key == "-" {
+		return n, nil
+	}
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || (key != "0" && strings.HasPrefix(key, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", key)
+	}
+	if i > n || (!insert && i == n) {
+		return 0, fmt.Errorf("array index %v out of bounds", i)
+	}
+	return i, nil
+}
+
+func containerGet(container any, key string) (any, error) {
+	switch c := container.(type) {
+	case map[string]any:
+		v, ok := c[key]
+		if !ok {
+			return nil, fmt.Errorf("%q not found", key)
+		}
+		return v, nil
+	case []any:
+		i, err := arrayIndex(key, len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		return c[i], nil
+	}
+	return nil, fmt.Errorf("can't look up %q in a %T", key, container)
+}
+
+// containerSet sets key to v and returns the container, which is a new slice if it's an array.
+// If insert is true, arrays get v inserted at the index rather than replacing.
+func containerSet(container any, key string, v any, insert bool) (any, error) {
+	switch c := container.(type) {
+	case map[string]any:
+		c[key] = v
+		return c, nil
+	case []any:
+		i, err := arrayIndex(key, len(c), insert)
+		if err != nil {
+			return nil, err
+		}
+		if !insert {
+			c[i] = v
+			return c, nil
+		}
+		c = append(c, nil)
+		copy(c[i+1:], c[i:])
+		c[i] = v
+		return c, nil
+	}
+	return nil, fmt.Errorf("can't set %q in a %T", key, container)
+}
+
+func containerRemove(container any, key string) (any, error) {
+	switch c := container.(type) {
+	case map[string]any:
+		if _, ok := c[key]; !ok {
+			return nil, fmt.Errorf("%q not found", key)
+		}
+		delete(c, key)
+		return c, nil
+	case []any:
+		i, err := arrayIndex(key, len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		return append(c[:i], c[i+1:]...), nil
+	}
+	return nil, fmt.Errorf("can't remove %q from a %T", key, container)
+}
+
+func deepCopyJSON(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(t))
+		for k, v2 := range t {
+			m[k] = deepCopyJSON(v2)
+		}
+		return m
+	case []any:
+		s := make([]any, len(t))
+		for i, v2 := range t {
+			s[i] = deepCopyJSON(v2)
+		}
+		return s
+	}
+	return v
+}
+
+// jsonEqual compares two decoded JSON values, treating numbers as equal if they have the same value.
+func jsonEqual(a, b any) bool {
+	switch at := a.(type) {
+	case json.Number:
+		bt, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		return normalizeNumber(string(at)) == normalizeNumber(string(bt))
+	case map[string]any:
+		bt, ok := b.(map[string]any)
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for k, v := range at {
+			v2, ok := bt[k]
+			if !ok || !jsonEqual(v, v2) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		bt, ok := b.([]any)
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for i := range at {
+			if !jsonEqual(at[i], bt[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// normalizeNumber returns the JSON number s as digits and an exponent with no leading or trailing zeros,
+// so numbers with the same exact value, such as 1, 1.0 and 10e-1, compare equal without losing
+// precision in a float64. Anything it can't parse is returned as is.
+func normalizeNumber(s string) string {
+	mant, exp := s, "0"
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		mant, exp = s[:i], s[i+1:]
+	}
+	e, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return s
+	}
+	sign := ""
+	if strings.HasPrefix(mant, "-") {
+		sign, mant = "-", mant[1:]
+	}
+	if i := strings.IndexByte(mant, '.'); i >= 0 {
+		e -= int64(len(mant) - i - 1)
+		mant = mant[:i] + mant[i+1:]
+	}
+	mant = strings.TrimLeft(mant, "0")
+	if mant == "" {
+		return "0"
+	}
+	trimmed := strings.TrimRight(mant, "0")
+	e += int64(len(mant) - len(trimmed))
+	return sign + trimmed + "e" + strconv.FormatInt(e, 10)
+}
@@ -0,0 +1,243 @@
+package gotils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyJSONPatch(t *testing.T) {
+	// examples from RFC 6902 appendix A
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"foo":null}`, `[{"op":"add","path":"/foo","value":1}]`, `{"foo":1}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10},{"op":"copy","from":"/~1","path":"/a"}]`, `{"/":9,"a":9,"~1":10}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2.0}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+	}
+	for i, tt := range tests {
+		ops := []PatchOp{}
+		if err := json.Unmarshal([]byte(tt.patch), &ops); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ApplyJSONPatch([]byte(tt.doc), ops)
+		if err != nil {
+			t.Errorf("%v: %v", i, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%v: expected %v, got %v", i, tt.want, string(got))
+		}
+	}
+
+	_, err := ApplyJSONPatch([]byte(`{"baz":"qux"}`), []PatchOp{{Op: "test", Path: "/baz", Value: json.RawMessage(`"bar"`)}})
+	var he HTTPError
+	if !errors.As(err, &he) || he.Code() != http.StatusConflict {
+		t.Error("expected 409 for failed test, got", err)
+	}
+	_, err = ApplyJSONPatch([]byte(`{"foo":"bar"}`), []PatchOp{{Op: "add", Path: "/baz/bat", Value: json.RawMessage(`"qux"`)}})
+	if !errors.As(err, &he) || he.Code() != http.StatusUnprocessableEntity {
+		t.Error("expected 422 for missing parent, got", err)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// example from RFC 7396
+	doc := `{"title":"Goodbye!","author":{"givenName":"John","familyName":"Doe"},"tags":["example","sample"],"content":"This will be unchanged"}`
+	patch := `{"title":"Hello!","phoneNumber":"+01-123-456-7890","author":{"familyName":null},"tags":["example"]}`
+	want := `{"author":{"givenName":"John"},"content":"This will be unchanged","phoneNumber":"+01-123-456-7890","tags":["example"],"title":"Hello!"}`
+	got, err := ApplyMergePatch([]byte(doc), []byte(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("expected %v, got %v", want, string(got))
+	}
+
+	// creating a patch from the two docs should get us back to the same place
+	var d1, d2 any
+	json.Unmarshal([]byte(doc), &d1)
+	json.Unmarshal([]byte(want), &d2)
+	p2, err := CreateMergePatch(d1, d2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = ApplyMergePatch([]byte(doc), p2)
+	if string(got) != want {
+		t.Errorf("CreateMergePatch: expected %v, got %v", want, string(got))
+	}
+	ops, err := CreateJSONPatch(d1, d2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = ApplyJSONPatch([]byte(doc), ops)
+	if string(got) != want {
+		t.Errorf("CreateJSONPatch: expected %v, got %v", want, string(got))
+	}
+}
+
+func TestPatchLargeNumbers(t *testing.T) {
+	// 2^53+1 and 2^53 are the same float64
+	type idThing struct {
+		ID int64 `json:"id"`
+	}
+	a, b := &idThing{ID: 9007199254740993}, &idThing{ID: 9007199254740992}
+	mp, err := CreateMergePatch(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mp) != `{"id":9007199254740992}` {
+		t.Errorf("CreateMergePatch: unexpected patch %s", mp)
+	}
+	ops, err := CreateJSONPatch(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0].Op != "replace" || string(ops[0].Value) != "9007199254740992" {
+		t.Errorf("CreateJSONPatch: unexpected ops %+v", ops)
+	}
+	_, err = ApplyJSONPatch([]byte(`{"id":9007199254740992}`), []PatchOp{{Op: "test", Path: "/id", Value: json.RawMessage(`9007199254740993`)}})
+	var he HTTPError
+	if !errors.As(err, &he) || he.Code() != http.StatusConflict {
+		t.Error("expected 409 for a different large number, got", err)
+	}
+
+	for x, y := range map[string]string{"1": "1.0", "10": "1e1", "0.5": "5e-1", "-0": "0.0", "12300": "1.23E+4"} {
+		if normalizeNumber(x) != normalizeNumber(y) {
+			t.Errorf("expected %v and %v to be equal", x, y)
+		}
+	}
+	for x, y := range map[string]string{"1": "-1", "10": "100", "12345678901234567890": "12345678901234567891"} {
+		if normalizeNumber(x) == normalizeNumber(y) {
+			t.Errorf("expected %v and %v to differ", x, y)
+		}
+	}
+}
+
+type patchThing struct {
+	Name  string   `json:"name"`
+	Email string   `json:"email,omitempty"`
+	Tags  []string `json:"tags"`
+}
+
+func TestParsePatch(t *testing.T) {
+	ts := httptest.NewServer(ErrorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodPatch {
+			t.Error("expected PATCH, got", r.Method)
+		}
+		current := &patchThing{Name: "a", Email: "a@example.com", Tags: []string{"x"}}
+		err := ParsePatch(w, r, current)
+		if err != nil {
+			return err
+		}
+		return WriteObject(w, 200, current)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	out := &patchThing{}
+	err := PatchMerge(ctx, ts.URL, map[string]any{"name": "b", "email": nil}, out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "b" || out.Email != "" || len(out.Tags) != 1 {
+		t.Errorf("unexpected merge result %+v", out)
+	}
+	out = &patchThing{}
+	err = PatchOps(ctx, ts.URL, []PatchOp{{Op: "test", Path: "/name", Value: json.RawMessage(`"a"`)}, {Op: "add", Path: "/tags/-", Value: json.RawMessage(`"y"`)}}, out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "a" || len(out.Tags) != 2 || out.Tags[1] != "y" {
+		t.Errorf("unexpected JSON Patch result %+v", out)
+	}
+	err = PatchOps(ctx, ts.URL, []PatchOp{{Op: "test", Path: "/name", Value: json.RawMessage(`"nope"`)}}, out, nil)
+	var he HTTPError
+	if !errors.As(err, &he) || he.Code() != http.StatusConflict {
+		t.Error("expected 409, got", err)
+	}
+}
+
+type patchAccount struct {
+	Name         string    `json:"name"`
+	Balance      int64     `json:"balance"`
+	Created      time.Time `json:"created,omitempty"`
+	Profile      patchProfile
+	PasswordHash string `json:"-"`
+	version      int
+}
+
+type patchProfile struct {
+	Bio    string `json:"bio"`
+	Secret string `json:"-"`
+}
+
+func TestApplyPatchKeepsHiddenFields(t *testing.T) {
+	acct := &patchAccount{Name: "a", Created: time.Now(), PasswordHash: "hash", version: 3, Profile: patchProfile{Bio: "hi", Secret: "s"}}
+	// removing a field zeroes it, hidden ones are kept
+	err := ApplyPatch(acct, ContentTypeJSONPatch, []byte(`[{"op":"replace","path":"/name","value":"b"},{"op":"remove","path":"/created"},{"op":"remove","path":"/Profile/bio"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.Name != "b" || !acct.Created.IsZero() || acct.Profile.Bio != "" {
+		t.Errorf("unexpected patch result %+v", acct)
+	}
+	if acct.PasswordHash != "hash" || acct.version != 3 || acct.Profile.Secret != "s" {
+		t.Errorf("expected hidden fields to be kept, got %+v", acct)
+	}
+
+	// big integers keep their precision
+	ops := []PatchOp{}
+	json.Unmarshal([]byte(`[{"op":"replace","path":"/balance","value":9007199254740993}]`), &ops)
+	b, _ := json.Marshal(ops)
+	if err := ApplyPatch(acct, ContentTypeJSONPatch, b); err != nil {
+		t.Fatal(err)
+	}
+	if acct.Balance != 9007199254740993 {
+		t.Errorf("expected 9007199254740993, got %v", acct.Balance)
+	}
+}
+
+func TestParsePatchBody(t *testing.T) {
+	h := ErrorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		current := &patchThing{Name: "a"}
+		if err := ParsePatch(w, r, current); err != nil {
+			return err
+		}
+		return WriteObject(w, 200, current)
+	})
+	patch := func(body []byte, gzipped bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PATCH", "/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", ContentTypeMergePatch)
+		if gzipped {
+			r.Header.Set("Content-Encoding", "gzip")
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	gz, _ := compressBody([]byte(`{"name":"b"}`))
+	if w := patch(gz, true); w.Code != 200 || !strings.Contains(w.Body.String(), `"name":"b"`) {
+		t.Errorf("expected a gzipped patch to apply, got %v %v", w.Code, w.Body.String())
+	}
+	defer func(max int64) { MaxPatchSize = max }(MaxPatchSize)
+	MaxPatchSize = 16
+	if w := patch([]byte(`{"name":"`+strings.Repeat("x", 100)+`"}`), false); w.Code != 413 || strings.Contains(w.Body.String(), "decompressed") {
+		t.Errorf("expected 413, got %v %v", w.Code, w.Body.String())
+	}
+}
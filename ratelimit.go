@@ -0,0 +1,149 @@
+package gotils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits requests per host with a token bucket and caps the number of requests in
+// flight. Set it on Client.Limiter or RequestOptions.Limiter; share one RateLimiter between
+// clients that should share a quota.
+//
+// It also adapts to the server: a Retry-After header, or X-RateLimit-Remaining: 0 along with
+// X-RateLimit-Reset, holds back requests to that host until the given time.
+//
+// Waiting respects the context, if the wait would go past the context deadline the request fails
+// straight away with an error wrapping ErrTimeout.
+type RateLimiter struct {
+	// Rate is the number of requests per second allowed per host. 0 means no rate limit.
+	Rate float64
+	// Burst is the number of requests that can be made at once before being limited by Rate. Defaults to 1.
+	Burst int
+	// MaxInFlight is the maximum number of concurrent requests per host. 0 means no limit.
+	MaxInFlight int
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimiter
+}
+
+type hostLimiter struct {
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+	inFlight     chan struct{}
+}
+
+func (rl *RateLimiter) burst() float64 {
+	if rl.Burst <= 0 {
+		return 1
+	}
+	return float64(rl.Burst)
+}
+
+func (rl *RateLimiter) host(host string) *hostLimiter {
+	if rl.hosts == nil {
+		rl.hosts = map[string]*hostLimiter{}
+	}
+	hl := rl.hosts[host]
+	if hl == nil {
+		hl = &hostLimiter{tokens: rl.burst(), last: time.Now()}
+		if rl.MaxInFlight > 0 {
+			hl.inFlight = make(chan struct{}, rl.MaxInFlight)
+		}
+		rl.hosts[host] = hl
+	}
+	return hl
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (rl *RateLimiter) reserve(host string) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	hl := rl.host(host)
+	now := time.Now()
+	var wait time.Duration
+	if rl.Rate > 0 {
+		hl.tokens += now.Sub(hl.last).Seconds() * rl.Rate
+		if hl.tokens > rl.burst() {
+			hl.tokens = rl.burst()
+		}
+		hl.last = now
+		hl.tokens--
+		if hl.tokens < 0 {
+			wait = time.Duration(-hl.tokens / rl.Rate * float64(time.Second))
+		}
+	}
+	if d := hl.blockedUntil.Sub(now); d > wait {
+		wait = d
+	}
+	return wait
+}
+
+// unreserve gives back a token that was reserved but not used.
+func (rl *RateLimiter) unreserve(host string) {
+	if rl.Rate <= 0 {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.host(host).tokens++
+}
+
+// Wait blocks until a request to host is allowed. The returned release func must be called once
+// the request is finished.
+func (rl *RateLimiter) Wait(ctx context.Context, host string) (release func(), err error) {
+	wait := rl.reserve(host)
+	if wait > 0 {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			rl.unreserve(host)
+			return nil, fmt.Errorf("rate limit wait of %v for %v exceeds deadline: %w", wait, host, context.DeadlineExceeded)
+		}
+		if err := sleepCtx(ctx, wait); err != nil {
+			rl.unreserve(host)
+			return nil, err
+		}
+	}
+	rl.mu.Lock()
+	sem := rl.host(host).inFlight
+	rl.mu.Unlock()
+	if sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case sem <- struct{}{}:
+		var once sync.Once
+		return func() { once.Do(func() { <-sem }) }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Observe adapts the limiter to the rate limit headers in a response from host.
+func (rl *RateLimiter) Observe(host string, h http.Header) {
+	until := time.Time{}
+	if d, ok := retryAfter(h); ok {
+		until = time.Now().Add(d)
+	} else if h.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			// some APIs send a unix timestamp, others the number of seconds left
+			if reset > 1e9 {
+				until = time.Unix(reset, 0)
+			} else {
+				until = time.Now().Add(time.Duration(reset) * time.Second)
+			}
+		}
+	}
+	if until.IsZero() {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	hl := rl.host(host)
+	if until.After(hl.blockedUntil) {
+		hl.blockedUntil = until
+	}
+}
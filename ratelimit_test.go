@@ -0,0 +1,49 @@
+package gotils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var remaining atomic.Value
+	remaining.Store("5")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", remaining.Load().(string))
+		w.Header().Set("X-RateLimit-Reset", "10")
+		WriteMessage(w, 200, "ok")
+	}))
+	defer ts.Close()
+
+	c := &Client{Limiter: &RateLimiter{Rate: 20, MaxInFlight: 1}}
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		err := c.GetJSON(ctx, ts.URL, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Error("expected requests to be rate limited, took", d)
+	}
+
+	// server says we're out of quota for 10 seconds, so this should fail fast
+	remaining.Store("0")
+	c.GetJSON(ctx, ts.URL, nil, nil)
+	ctx2, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	start = time.Now()
+	err := c.GetJSON(ctx2, ts.URL, nil, nil)
+	if !errors.Is(err, ErrTimeout) {
+		t.Error("expected ErrTimeout, got", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Error("expected to fail without waiting, took", d)
+	}
+}
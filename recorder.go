@@ -0,0 +1,274 @@
+package gotils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+)
+
+// RecorderMode controls whether a Recorder uses the network.
+type RecorderMode int
+
+const (
+	// RecorderAuto replays if the cassette file exists, otherwise records.
+	RecorderAuto RecorderMode = iota
+	// RecorderReplay only replays from the cassette.
+	RecorderReplay
+	// RecorderRecord always makes real requests and records them, overwriting the cassette on Save.
+	RecorderRecord
+)
+
+// ErrNoInteraction is returned by a strict Recorder when a request doesn't match anything in the cassette.
+var ErrNoInteraction = errors.New("no recorded interaction matches request")
+
+// Recorder is an http.RoundTripper that records real HTTP interactions to a cassette file and replays
+// them later, so tests of client code don't need a server or the network.
+//
+//	rec, err := gotils.NewRecorder("testdata/users.json", gotils.RecorderAuto)
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer rec.Save()
+//	c := rec.Client()
+//	err = c.GetJSON(ctx, "https://api.example.com/users/1", user, nil)
+//
+// Requests are matched on method, URL and body. Identical requests are replayed in the order they were recorded.
+type Recorder struct {
+	// Path of the cassette file.
+	Path string
+	Mode RecorderMode
+	// Strict fails requests that don't match the cassette with ErrNoInteraction. Otherwise they're
+	// sent for real and recorded.
+	Strict bool
+	// RedactHeaders are replaced with "REDACTED" in the cassette. Defaults to Authorization, Cookie,
+	// Set-Cookie and X-Api-Key.
+	RedactHeaders []string
+	// Next is the transport used for real requests, defaults to http.DefaultTransport.
+	Next http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+	changed      bool
+}
+
+// Interaction is a recorded request and response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the request part of an Interaction.
+type RecordedRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+	// BodyEncoding is "base64" if the body isn't valid UTF-8.
+	BodyEncoding string `json:"body_encoding,omitempty"`
+}
+
+// RecordedResponse is the response part of an Interaction.
+type RecordedResponse struct {
+	Status       int         `json:"status"`
+	Headers      http.Header `json:"headers,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+type cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// NewRecorder returns a Recorder for the cassette at path, loading it if it exists.
+func NewRecorder(path string, mode RecorderMode) (*Recorder, error) {
+	r := &Recorder{Path: path, Mode: mode}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if mode == RecorderReplay {
+			return nil, fmt.Errorf("cassette %v not found: %w", path, err)
+		}
+		r.Mode = RecorderRecord
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if mode == RecorderRecord {
+		// start fresh
+		return r, nil
+	}
+	c := &cassette{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("invalid cassette %v: %w", path, err)
+	}
+	r.interactions = c.Interactions
+	r.used = make([]bool, len(c.Interactions))
+	if mode == RecorderAuto {
+		r.Mode = RecorderReplay
+	}
+	return r, nil
+}
+
+// Client returns a Client that sends everything through the recorder.
+func (r *Recorder) Client() *Client {
+	return &Client{HTTPClient: &http.Client{Transport: r}}
+}
+
+func (r *Recorder) next() http.RoundTripper {
+	if r.Next != nil {
+		return r.Next
+	}
+	return http.DefaultTransport
+}
+
+func encodeBody(b []byte) (string, string) {
+	if utf8.Valid(b) {
+		return string(b), ""
+	}
+	return base64.StdEncoding.EncodeToString(b), "base64"
+}
+
+func decodeBody(s, encoding string) []byte {
+	if encoding == "base64" {
+		b, _ := base64.StdEncoding.DecodeString(s)
+		return b
+	}
+	return []byte(s)
+}
+
+// RoundTrip replays or records the request.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if r.Mode == RecorderReplay {
+		if in := r.match(req.Method, req.URL.String(), string(body)); in != nil {
+			return in.Response.toResponse(req), nil
+		}
+		if r.Strict {
+			return nil, fmt.Errorf("%w: %v %v", ErrNoInteraction, req.Method, req.URL)
+		}
+	}
+
+	resp, err := r.next().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	in := &Interaction{
+		Request:  RecordedRequest{Method: req.Method, URL: req.URL.String(), Headers: r.redact(req.Header)},
+		Response: RecordedResponse{Status: resp.StatusCode, Headers: r.redact(resp.Header)},
+	}
+	in.Request.Body, in.Request.BodyEncoding = encodeBody(body)
+	in.Response.Body, in.Response.BodyEncoding = encodeBody(respBody)
+	r.mu.Lock()
+	r.interactions = append(r.interactions, in)
+	r.used = append(r.used, true)
+	r.changed = true
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// match returns the first unused matching interaction, or the last used one if they've all been replayed.
+func (r *Recorder) match(method, url, body string) *Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var last *Interaction
+	for i, in := range r.interactions {
+		if in.Request.Method != method || in.Request.URL != url || string(decodeBody(in.Request.Body, in.Request.BodyEncoding)) != body {
+			continue
+		}
+		if !r.used[i] {
+			r.used[i] = true
+			return in
+		}
+		last = in
+	}
+	return last
+}
+
+func (r *Recorder) redact(h http.Header) http.Header {
+	return redactHeaders(h, r.RedactHeaders)
+}
+
+// defaultRedactHeaders are the headers left out of recordings and logs by default.
+var defaultRedactHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// redactHeaders returns a copy of h with the headers in redact, or defaultRedactHeaders if nil,
+// replaced with "REDACTED".
+func redactHeaders(h http.Header, redact []string) http.Header {
+	if redact == nil {
+		redact = defaultRedactHeaders
+	}
+	h2 := h.Clone()
+	for _, k := range redact {
+		if h2.Get(k) != "" {
+			h2.Set(k, "REDACTED")
+		}
+	}
+	return h2
+}
+
+func (rr *RecordedResponse) toResponse(req *http.Request) *http.Response {
+	body := decodeBody(rr.Body, rr.BodyEncoding)
+	h := rr.Headers.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rr.Status, http.StatusText(rr.Status)),
+		StatusCode:    rr.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// Save writes the cassette if anything new was recorded.
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.changed {
+		return nil
+	}
+	b, err := json.MarshalIndent(&cassette{Interactions: r.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(r.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(r.Path, b, 0644); err != nil {
+		return err
+	}
+	r.changed = false
+	return nil
+}
@@ -0,0 +1,65 @@
+package gotils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	n := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		in := &clientThing{}
+		ParseJSON(w, r, in)
+		WriteObject(w, 200, &clientThing{Name: in.Name + strings.Repeat("!", n)})
+	}))
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	rec, err := NewRecorder(path, RecorderAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := rec.Client()
+	out := &clientThing{}
+	opts := &RequestOptions{Headers: map[string]string{"Authorization": "Bearer secret"}}
+	for i := 0; i < 2; i++ {
+		if err := c.PostJSON(ctx, ts.URL, &clientThing{Name: "hi"}, out, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+	b, _ := os.ReadFile(path)
+	if strings.Contains(string(b), "secret") {
+		t.Error("expected Authorization to be redacted")
+	}
+
+	// server is gone now, so this has to come from the cassette
+	rec, err = NewRecorder(path, RecorderAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Strict = true
+	c = rec.Client()
+	for _, want := range []string{"hi!", "hi!!"} {
+		if err := c.PostJSON(ctx, ts.URL, &clientThing{Name: "hi"}, out, opts); err != nil {
+			t.Fatal(err)
+		}
+		if out.Name != want {
+			t.Errorf("expected %v, got %v", want, out.Name)
+		}
+	}
+	err = c.PostJSON(ctx, ts.URL, &clientThing{Name: "other"}, out, opts)
+	if !errors.Is(err, ErrNoInteraction) {
+		t.Error("expected ErrNoInteraction, got", err)
+	}
+}
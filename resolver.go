@@ -0,0 +1,131 @@
+package gotils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrCIDMismatch is returned when content fetched through a gateway doesn't match its CID.
+var ErrCIDMismatch = errors.New("content doesn't match CID")
+
+// Resolver maps URL schemes such as ipfs:// to an ordered list of HTTP gateways. The client tries
+// each gateway in turn until one works. ipfs://, ipns:// and ar:// are set up by default.
+type Resolver struct {
+	mu       sync.RWMutex
+	gateways map[string][]string
+}
+
+// DefaultResolver is used by clients that don't set Client.Resolver.
+var DefaultResolver = NewResolver()
+
+// NewResolver returns a Resolver with the default gateways.
+func NewResolver() *Resolver {
+	r := &Resolver{gateways: map[string][]string{}}
+	r.Register("ipfs", "https://ipfs.io/ipfs/", "https://dweb.link/ipfs/")
+	r.Register("ipns", "https://ipfs.io/ipns/", "https://dweb.link/ipns/")
+	r.Register("ar", "https://arweave.net/")
+	return r
+}
+
+// Register sets the gateways for scheme, replacing any existing ones. The rest of the URL after
+// "scheme://" is appended to the gateway, so with Register("ipfs", "https://ipfs.io/ipfs/"),
+// ipfs://<cid>/a.json becomes https://ipfs.io/ipfs/<cid>/a.json. Registering no gateways removes the scheme.
+func (r *Resolver) Register(scheme string, gateways ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	scheme = strings.ToLower(strings.TrimSuffix(scheme, "://"))
+	if len(gateways) == 0 {
+		delete(r.gateways, scheme)
+		return
+	}
+	r.gateways[scheme] = append([]string{}, gateways...)
+}
+
+// Resolve returns the URLs to try for url, in order. URLs with unregistered schemes are returned as is.
+func (r *Resolver) Resolve(url string) []string {
+	scheme, rest, ok := strings.Cut(url, "://")
+	if !ok {
+		return []string{url}
+	}
+	r.mu.RLock()
+	gateways := r.gateways[strings.ToLower(scheme)]
+	r.mu.RUnlock()
+	if len(gateways) == 0 {
+		return []string{url}
+	}
+	urls := make([]string, len(gateways))
+	for i, g := range gateways {
+		urls[i] = g + rest
+	}
+	return urls
+}
+
+// RegisterGateways sets the gateways for scheme on the DefaultResolver. See Resolver.Register.
+func RegisterGateways(scheme string, gateways ...string) {
+	DefaultResolver.Register(scheme, gateways...)
+}
+
+// cidDigest returns the digest to check content from an ipfs:// URL against, or nil for other URLs.
+// It's an error if the CID can't be verified, which is checked before sending the request.
+func cidDigest(url string) ([]byte, error) {
+	rest, ok := strings.CutPrefix(url, "ipfs://")
+	if !ok {
+		return nil, nil
+	}
+	cid, path, _ := strings.Cut(rest, "/")
+	if path != "" {
+		return nil, fmt.Errorf("can't verify CID for a path inside a directory: %v", url)
+	}
+	return rawCIDDigest(cid)
+}
+
+// verifyCID reads the response body and checks it against digest, then replaces the body so it can
+// be read as normal. The body is closed if it doesn't match.
+func verifyCID(url string, digest []byte, resp *http.Response) error {
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	if !bytes.Equal(sum[:], digest) {
+		return fmt.Errorf("%w: %v", ErrCIDMismatch, url)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	return nil
+}
+
+// rawCIDDigest returns the sha2-256 digest from a base32 CIDv1 with the raw codec. Other CIDs
+// (such as Qm... CIDv0s) hash the dag-pb encoding rather than the file bytes, so can't be
+// checked against the content the gateway returns.
+func rawCIDDigest(cid string) ([]byte, error) {
+	if !strings.HasPrefix(cid, "b") {
+		return nil, fmt.Errorf("can only verify base32 CIDv1s, got %v", cid)
+	}
+	b, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(cid[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CID %v: %w", cid, err)
+	}
+	r := bytes.NewReader(b)
+	var fields [4]uint64 // version, codec, hash function, digest length
+	for i := range fields {
+		fields[i], err = binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CID %v: %w", cid, err)
+		}
+	}
+	if fields[0] != 1 || fields[1] != 0x55 || fields[2] != 0x12 || fields[3] != sha256.Size || r.Len() != sha256.Size {
+		return nil, fmt.Errorf("can only verify raw sha2-256 CIDs, got %v", cid)
+	}
+	digest := make([]byte, sha256.Size)
+	r.Read(digest)
+	return digest, nil
+}
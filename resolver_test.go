@@ -0,0 +1,80 @@
+package gotils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolver(t *testing.T) {
+	content := []byte(`{"name":"from ipfs"}`)
+	sum := sha256.Sum256(content)
+	cid := "b" + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(append([]byte{1, 0x55, 0x12, 32}, sum[:]...)))
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, 502, errors.New("bad gateway"))
+	}))
+	defer down.Close()
+	liar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":"not what you asked for"}`))
+	}))
+	defer liar.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ipfs/"+cid {
+			WriteError(w, 404, ErrNotFound)
+			return
+		}
+		w.Write(content)
+	}))
+	defer good.Close()
+
+	ctx := context.Background()
+	r := NewResolver()
+	r.Register("ipfs", down.URL+"/ipfs/", liar.URL+"/ipfs/", good.URL+"/ipfs/")
+	c := &Client{Resolver: r}
+	out := &clientThing{}
+	err := c.GetJSON(ctx, "ipfs://"+cid, out, &RequestOptions{VerifyCID: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "from ipfs" {
+		t.Error("expected the verified content, got", out.Name)
+	}
+
+	// without verification the liar wins
+	err = c.GetJSON(ctx, "ipfs://"+cid, out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "not what you asked for" {
+		t.Error("expected the second gateway's content, got", out.Name)
+	}
+
+	r.Register("ipfs", liar.URL+"/ipfs/")
+	err = c.GetJSON(ctx, "ipfs://"+cid, out, &RequestOptions{VerifyCID: true})
+	if !errors.Is(err, ErrCIDMismatch) {
+		t.Error("expected ErrCIDMismatch, got", err)
+	}
+
+	// CIDs that can't be verified fail without sending anything
+	requests := 0
+	counter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(content)
+	}))
+	defer counter.Close()
+	r.Register("ipfs", counter.URL+"/ipfs/", counter.URL+"/ipfs/")
+	for _, u := range []string{"ipfs://QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG", "ipfs://" + cid + "/a.json"} {
+		if err := c.GetJSON(ctx, u, out, &RequestOptions{VerifyCID: true}); err == nil {
+			t.Error("expected an error verifying", u)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("expected no requests, got %v", requests)
+	}
+}
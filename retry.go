@@ -0,0 +1,155 @@
+package gotils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures automatic retries in the HTTP client. Set it on Client.Retry or
+// RequestOptions.Retry. The zero value of each field uses a sensible default, so
+// &gotils.RetryPolicy{MaxAttempts: 3} is all you usually need.
+//
+// Non-idempotent requests (POST and PATCH) are only retried if RetryNonIdempotent is set or the
+// request has an Idempotency-Key header.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one. 1 or less disables retries.
+	MaxAttempts int
+	// InitialBackoff is the base delay before the first retry, doubled on each attempt. Defaults to 100ms.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts. Defaults to 10s. If the server's Retry-After asks
+	// for longer than this, the request isn't retried.
+	MaxBackoff time.Duration
+	// RetryStatus reports whether a response status should be retried. Defaults to RetryableStatus.
+	RetryStatus func(code int) bool
+	// RetryError reports whether an error that happened before getting a response should be retried.
+	// Defaults to RetryableError.
+	RetryError func(err error) bool
+	// RetryNonIdempotent allows retrying POST and PATCH requests.
+	RetryNonIdempotent bool
+}
+
+// RetryableStatus returns true for 429, 502, 503 and 504.
+func RetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RetryableError returns true for connection resets, refused connections, unexpected EOFs and
+// timeouts of a single attempt.
+func RetryableError(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, ErrTimeout)
+}
+
+func (p *RetryPolicy) attempts() int {
+	if p == nil || p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) allows(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodPost, http.MethodPatch:
+		return p.RetryNonIdempotent || req.Header.Get("Idempotency-Key") != ""
+	}
+	return true
+}
+
+// shouldRetry looks at the result of an attempt. resp is set if the server responded.
+func (p *RetryPolicy) shouldRetry(resp *http.Response, err error) bool {
+	if resp != nil {
+		if p.RetryStatus != nil {
+			return p.RetryStatus(resp.StatusCode)
+		}
+		return RetryableStatus(resp.StatusCode)
+	}
+	if p.RetryError != nil {
+		return p.RetryError(err)
+	}
+	return RetryableError(err)
+}
+
+// replayable reports whether body can be buffered to be sent again. Streaming bodies such as
+// multipart uploads can't.
+func replayable(body io.Reader) bool {
+	_, streaming := body.(interface{ streaming() })
+	return !streaming
+}
+
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff <= 0 {
+		return 10 * time.Second
+	}
+	return p.MaxBackoff
+}
+
+// backoff returns how long to wait before the next attempt, using full jitter or the
+// server's Retry-After header if there is one. It returns false if Retry-After is longer
+// than MaxBackoff.
+func (p *RetryPolicy) backoff(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if d, ok := retryAfter(resp.Header); ok {
+			return d, d <= p.maxBackoff()
+		}
+	}
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	d := initial << (attempt - 1)
+	if d <= 0 || d > p.maxBackoff() {
+		d = p.maxBackoff()
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1)), true
+}
+
+// retryAfter parses a Retry-After header, which is either seconds or an HTTP date.
+func retryAfter(h http.Header) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			secs = 0
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// sleepCtx waits for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		// no point waiting if we'll run out of time anyways
+		return context.DeadlineExceeded
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
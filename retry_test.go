@@ -0,0 +1,100 @@
+package gotils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyAllows(t *testing.T) {
+	tests := []struct {
+		method        string
+		key           bool
+		nonIdempotent bool
+		want          bool
+	}{
+		{"GET", false, false, true},
+		{"HEAD", false, false, true},
+		{"OPTIONS", false, false, true},
+		{"PUT", false, false, true},
+		{"DELETE", false, false, true},
+		{"POST", false, false, false},
+		{"PATCH", false, false, false},
+		{"POST", true, false, true},
+		{"PATCH", true, false, true},
+		{"POST", false, true, true},
+		{"PATCH", false, true, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", nil)
+		if tt.key {
+			r.Header.Set("Idempotency-Key", "abc")
+		}
+		p := &RetryPolicy{MaxAttempts: 3, RetryNonIdempotent: tt.nonIdempotent}
+		if got := p.allows(r); got != tt.want {
+			t.Errorf("%v key=%v nonIdempotent=%v: expected %v, got %v", tt.method, tt.key, tt.nonIdempotent, tt.want, got)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	custom := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		name    string
+		policy  *RetryPolicy
+		attempt int
+		max     time.Duration
+	}{
+		{"first", custom, 1, 100 * time.Millisecond},
+		{"doubled", custom, 2, 200 * time.Millisecond},
+		{"doubled again", custom, 4, 800 * time.Millisecond},
+		{"capped", custom, 5, time.Second},
+		{"overflow", custom, 100, time.Second},
+		{"default first", &RetryPolicy{}, 1, 100 * time.Millisecond},
+		{"default cap", &RetryPolicy{}, 20, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 100; i++ {
+			d, ok := tt.policy.backoff(tt.attempt, nil)
+			if !ok || d < 0 || d > tt.max {
+				t.Fatalf("%v: expected 0 to %v, got %v %v", tt.name, tt.max, d, ok)
+			}
+			seen[d] = true
+		}
+		// full jitter spreads the waits out
+		if len(seen) < 10 {
+			t.Errorf("%v: expected jittered backoffs, got %v different values", tt.name, len(seen))
+		}
+	}
+}
+
+func TestRetryPolicyRetryAfter(t *testing.T) {
+	p := &RetryPolicy{MaxBackoff: 10 * time.Second}
+	tests := []struct {
+		retryAfter string
+		min, max   time.Duration
+		ok         bool
+	}{
+		{"2", 2 * time.Second, 2 * time.Second, true},
+		{"10", 10 * time.Second, 10 * time.Second, true},
+		{"0", 0, 0, true},
+		{"-5", 0, 0, true},
+		// longer than MaxBackoff isn't retried
+		{"11", 11 * time.Second, 11 * time.Second, false},
+		{"3600", time.Hour, time.Hour, false},
+		{time.Now().Add(5 * time.Second).UTC().Format(http.TimeFormat), 3 * time.Second, 5 * time.Second, true},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 59 * time.Minute, time.Hour, false},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0, true},
+		// unparseable falls back to the normal backoff
+		{"soon", 0, 100 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{"Retry-After": {tt.retryAfter}}}
+		d, ok := p.backoff(1, resp)
+		if ok != tt.ok || d < tt.min || d > tt.max {
+			t.Errorf("Retry-After %v: expected %v to %v and %v, got %v %v", tt.retryAfter, tt.min, tt.max, tt.ok, d, ok)
+		}
+	}
+}
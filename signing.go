@@ -0,0 +1,245 @@
+package gotils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SignatureFormat is the header format used to sign and verify requests.
+type SignatureFormat int
+
+const (
+	// SignatureDefault sends X-Signature-Timestamp with the unix time and X-Signature with
+	// "v1=" + hex(HMAC-SHA256(secret, timestamp + "." + body)).
+	SignatureDefault SignatureFormat = iota
+	// SignatureStripe is Stripe's Stripe-Signature header, "t=<timestamp>,v1=<signature>" signed the
+	// same way as SignatureDefault.
+	SignatureStripe
+	// SignatureGitHub is GitHub's X-Hub-Signature-256 header, "sha256=" + hex(HMAC-SHA256(secret, body)).
+	// There's no timestamp, so a replayed body is only rejected if it's seen again within Tolerance.
+	SignatureGitHub
+)
+
+// HMACSigner signs outgoing requests with HMAC-SHA256 over the timestamp and body. Set it as
+// RequestOptions.Sign, or Client.Sign to sign every request.
+//
+//	err := c.PostJSON(ctx, webhookURL, event, nil, &gotils.RequestOptions{Sign: &gotils.HMACSigner{Secret: secret}})
+type HMACSigner struct {
+	Secret string
+	Format SignatureFormat
+}
+
+// Sign adds the signature headers to req. The body must be replayable, which it is for everything
+// except streaming uploads.
+func (s *HMACSigner) Sign(req *http.Request) error {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return errors.New("can't sign a streaming request body")
+		}
+		rc, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		body, err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	switch s.Format {
+	case SignatureStripe:
+		req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%v,v1=%v", ts, sign(s.Secret, ts+"."+string(body))))
+	case SignatureGitHub:
+		req.Header.Set("X-Hub-Signature-256", "sha256="+sign(s.Secret, string(body)))
+	default:
+		req.Header.Set("X-Signature-Timestamp", ts)
+		req.Header.Set("X-Signature", "v1="+sign(s.Secret, ts+"."+string(body)))
+	}
+	return nil
+}
+
+// Authenticate signs req, so an HMACSigner can also be used as an Authenticator.
+func (s *HMACSigner) Authenticate(ctx context.Context, req *http.Request) error {
+	return s.Sign(req)
+}
+
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookVerifier checks the signatures on incoming requests made with HMACSigner, Stripe or GitHub.
+// Requests with a missing or wrong signature, a timestamp outside Tolerance or that have been seen
+// before get an HTTPError with code 401.
+//
+//	v := &gotils.WebhookVerifier{Secrets: []string{secret}}
+//	r.Post("/webhook", gotils.ErrorHandler(v.Wrap(handleWebhook)))
+type WebhookVerifier struct {
+	// Secrets that signatures are accepted for. More than one allows secrets to be rotated.
+	Secrets []string
+	Format  SignatureFormat
+	// Tolerance is how far the timestamp can be from now. Defaults to 5 minutes.
+	Tolerance time.Duration
+	// AllowReplays turns off replay protection, which remembers signatures for Tolerance and rejects repeats.
+	AllowReplays bool
+	// MaxBodySize defaults to 10 MB.
+	MaxBodySize int64
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func (v *WebhookVerifier) tolerance() time.Duration {
+	if v.Tolerance <= 0 {
+		return 5 * time.Minute
+	}
+	return v.Tolerance
+}
+
+func (v *WebhookVerifier) maxBodySize() int64 {
+	if v.MaxBodySize <= 0 {
+		return 10 << 20
+	}
+	return v.MaxBodySize
+}
+
+func unauthorized(msg string) error {
+	return NewHTTPError(msg, http.StatusUnauthorized)
+}
+
+// Verify checks the signature on r. The body is read and replaced so the handler can still read it.
+func (v *WebhookVerifier) Verify(r *http.Request) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, v.maxBodySize()+1))
+	r.Body.Close()
+	if err != nil {
+		return C(r.Context()).Errorf("couldn't read body: %w", err)
+	}
+	if int64(len(body)) > v.maxBodySize() {
+		return NewHTTPError("request body too large", http.StatusRequestEntityTooLarge)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var ts string
+	var sigs []string
+	switch v.Format {
+	case SignatureStripe:
+		for _, part := range strings.Split(r.Header.Get("Stripe-Signature"), ",") {
+			k, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch k {
+			case "t":
+				ts = val
+			case "v1":
+				sigs = append(sigs, val)
+			}
+		}
+	case SignatureGitHub:
+		if sig, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256="); ok {
+			sigs = append(sigs, sig)
+		}
+	default:
+		ts = r.Header.Get("X-Signature-Timestamp")
+		for _, part := range strings.Split(r.Header.Get("X-Signature"), ",") {
+			if sig, ok := strings.CutPrefix(strings.TrimSpace(part), "v1="); ok {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	if len(sigs) == 0 {
+		return unauthorized("missing signature")
+	}
+
+	payload := string(body)
+	if v.Format != SignatureGitHub {
+		if ts == "" {
+			return unauthorized("missing signature timestamp")
+		}
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return unauthorized("invalid signature timestamp")
+		}
+		if d := time.Since(time.Unix(sec, 0)); d > v.tolerance() || d < -v.tolerance() {
+			return unauthorized("signature timestamp outside tolerance")
+		}
+		payload = ts + "." + payload
+	}
+	sig, ok := v.matches(payload, sigs)
+	if !ok {
+		return unauthorized("invalid signature")
+	}
+
+	// key on the signature that matched, which covers the timestamp and body, so adding signatures
+	// or changing unsigned headers doesn't make a replay look new
+	if !v.AllowReplays {
+		if v.replayed(sig) {
+			return unauthorized("request already received")
+		}
+	}
+	return nil
+}
+
+// matches returns the expected signature if one of sigs is valid for payload.
+func (v *WebhookVerifier) matches(payload string, sigs []string) (string, bool) {
+	for _, secret := range v.Secrets {
+		want := sign(secret, payload)
+		for _, sig := range sigs {
+			if hmac.Equal([]byte(want), []byte(strings.ToLower(sig))) {
+				return want, true
+			}
+		}
+	}
+	return "", false
+}
+
+// replayed records key and returns whether it was seen within the tolerance.
+func (v *WebhookVerifier) replayed(key string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	if v.seen == nil {
+		v.seen = map[string]time.Time{}
+	}
+	for k, exp := range v.seen {
+		if now.After(exp) {
+			delete(v.seen, k)
+		}
+	}
+	if _, ok := v.seen[key]; ok {
+		return true
+	}
+	// timestamps can be up to tolerance in the future too
+	v.seen[key] = now.Add(2 * v.tolerance())
+	return false
+}
+
+// Wrap returns a handler that verifies the request before calling h.
+func (v *WebhookVerifier) Wrap(h ErrorHandlerFunc) ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if err := v.Verify(r); err != nil {
+			return err
+		}
+		return h(w, r)
+	}
+}
+
+// Handler returns an http.Handler that verifies the request before calling h, responding with the
+// error if it fails.
+func (v *WebhookVerifier) Handler(h http.Handler) http.Handler {
+	return ErrorHandler(v.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		h.ServeHTTP(w, r)
+		return nil
+	}))
+}
@@ -0,0 +1,117 @@
+package gotils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	ctx := context.Background()
+	for _, format := range []SignatureFormat{SignatureDefault, SignatureStripe, SignatureGitHub} {
+		v := &WebhookVerifier{Secrets: []string{"old", "s3cret"}, Format: format}
+		var got string
+		ts := httptest.NewServer(ErrorHandler(v.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			in := map[string]string{}
+			if err := ParseJSON(w, r, &in); err != nil {
+				return err
+			}
+			got = in["event"]
+			return WriteObject(w, 200, in)
+		})))
+		c := NewClient(ts.URL)
+		expect401 := func(err error) {
+			t.Helper()
+			var he HTTPError
+			if !errors.As(err, &he) || he.Code() != 401 {
+				t.Errorf("format %v: expected 401, got %v", format, err)
+			}
+		}
+
+		opts := &RequestOptions{Sign: &HMACSigner{Secret: "s3cret", Format: format}}
+		if format == SignatureGitHub {
+			opts.Headers = map[string]string{"X-GitHub-Delivery": "abc"}
+		}
+		if err := c.PostJSON(ctx, "/", map[string]string{"event": "paid"}, nil, opts); err != nil {
+			t.Fatal(format, err)
+		}
+		if got != "paid" {
+			t.Errorf("format %v: handler didn't get the body", format)
+		}
+
+		// send the exact same request twice
+		req, _ := http.NewRequest("POST", ts.URL, strings.NewReader(`{"event":"again"}`))
+		req.Header.Set("X-GitHub-Delivery", "def")
+		opts.Sign.Sign(req)
+		for i, want := range []int{200, 401} {
+			req.Body = io.NopCloser(strings.NewReader(`{"event":"again"}`))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != want {
+				t.Errorf("format %v: attempt %v expected %v, got %v", format, i+1, want, resp.StatusCode)
+			}
+		}
+
+		// changing the unsigned parts of a captured request doesn't get it past replay protection
+		tampers := map[string]func(h http.Header){
+			"extra signature": func(h http.Header) {
+				for _, k := range []string{"X-Signature", "Stripe-Signature"} {
+					if h.Get(k) != "" {
+						h.Set(k, h.Get(k)+",v1=junk")
+					}
+				}
+			},
+			"upper case hex": func(h http.Header) {
+				for _, k := range []string{"X-Signature", "Stripe-Signature", "X-Hub-Signature-256"} {
+					if v := h.Get(k); v != "" {
+						prefix, sig, _ := strings.Cut(v, "=")
+						if format == SignatureStripe {
+							prefix, sig, _ = strings.Cut(v, ",v1=")
+							prefix += ",v1"
+						}
+						h.Set(k, prefix+"="+strings.ToUpper(sig))
+					}
+				}
+			},
+			"new delivery id": func(h http.Header) { h.Set("X-GitHub-Delivery", "ghi") },
+		}
+		for name, tamper := range tampers {
+			req2 := req.Clone(ctx)
+			tamper(req2.Header)
+			req2.Body = io.NopCloser(strings.NewReader(`{"event":"again"}`))
+			resp, err := http.DefaultClient.Do(req2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != 401 || !strings.Contains(string(body), "already received") {
+				t.Errorf("format %v: replay with %v expected 401 already received, got %v %s", format, name, resp.StatusCode, body)
+			}
+		}
+
+		expect401(c.PostJSON(ctx, "/", map[string]string{"event": "x"}, nil, &RequestOptions{Sign: &HMACSigner{Secret: "wrong", Format: format}}))
+		expect401(c.PostJSON(ctx, "/", map[string]string{"event": "x"}, nil, nil))
+		ts.Close()
+	}
+
+	// old timestamps are rejected
+	v := &WebhookVerifier{Secrets: []string{"s3cret"}}
+	req := httptest.NewRequest("POST", "/", strings.NewReader("{}"))
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	req.Header.Set("X-Signature-Timestamp", old)
+	req.Header.Set("X-Signature", "v1="+sign("s3cret", old+".{}"))
+	var he HTTPError
+	if err := v.Verify(req); !errors.As(err, &he) || he.Code() != 401 || !strings.Contains(err.Error(), "tolerance") {
+		t.Error("expected an expired timestamp error, got", err)
+	}
+}
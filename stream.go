@@ -0,0 +1,315 @@
+package gotils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	ContentTypeNDJSON = "application/x-ndjson"
+	ContentTypeJSON   = "application/json; charset=utf-8"
+)
+
+// StreamOptions configures a StreamWriter.
+type StreamOptions struct {
+	// Array writes the values as elements of a single JSON array instead of newline delimited JSON.
+	Array bool
+	// FlushEvery flushes after this many values have been written. Defaults to 100.
+	FlushEvery int
+	// FlushInterval flushes if this much time has passed since the last flush. Defaults to 1 second.
+	FlushInterval time.Duration
+}
+
+// StreamWriter encodes JSON values one at a time straight to the ResponseWriter so large
+// result sets never have to be held in memory. Use NewStreamWriter to create one, call Write for
+// each value and Close when done.
+//
+//	sw := gotils.NewStreamWriter(ctx, w, 200, nil)
+//	for rows.Next() {
+//		...
+//		if err := sw.Write(row); err != nil {
+//			return err
+//		}
+//	}
+//	return sw.Close()
+type StreamWriter struct {
+	ctx       context.Context
+	w         http.ResponseWriter
+	enc       *json.Encoder
+	opts      StreamOptions
+	count     int
+	pending   int
+	lastFlush time.Time
+	closed    bool
+}
+
+// NewStreamWriter writes the headers and returns a StreamWriter. ctx is checked on every write so
+// the stream stops as soon as the client goes away, typically you'd pass in r.Context().
+func NewStreamWriter(ctx context.Context, w http.ResponseWriter, code int, opts *StreamOptions) *StreamWriter {
+	sw := &StreamWriter{ctx: ctx, w: w, enc: json.NewEncoder(w), lastFlush: time.Now()}
+	if opts != nil {
+		sw.opts = *opts
+	}
+	if sw.opts.FlushEvery <= 0 {
+		sw.opts.FlushEvery = 100
+	}
+	if sw.opts.FlushInterval <= 0 {
+		sw.opts.FlushInterval = time.Second
+	}
+	if sw.opts.Array {
+		w.Header().Set("Content-Type", ContentTypeJSON)
+	} else {
+		w.Header().Set("Content-Type", ContentTypeNDJSON)
+	}
+	w.WriteHeader(code)
+	return sw
+}
+
+// Write encodes v and writes it to the stream.
+func (sw *StreamWriter) Write(v any) error {
+	if sw.closed {
+		return errors.New("write on closed StreamWriter")
+	}
+	if err := sw.ctx.Err(); err != nil {
+		return err
+	}
+	if sw.opts.Array {
+		sep := ","
+		if sw.count == 0 {
+			sep = "["
+		}
+		if _, err := io.WriteString(sw.w, sep); err != nil {
+			return err
+		}
+	}
+	// Encode adds a trailing newline which is exactly what NDJSON needs and harmless in an array
+	if err := sw.enc.Encode(v); err != nil {
+		return err
+	}
+	sw.count++
+	sw.pending++
+	if sw.pending >= sw.opts.FlushEvery || time.Since(sw.lastFlush) >= sw.opts.FlushInterval {
+		sw.Flush()
+	}
+	return nil
+}
+
+// Count returns the number of values written so far.
+func (sw *StreamWriter) Count() int {
+	return sw.count
+}
+
+// Flush sends anything buffered to the client.
+func (sw *StreamWriter) Flush() {
+	sw.pending = 0
+	sw.lastFlush = time.Now()
+	if f, ok := sw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close terminates the stream, closing the array if needed, and flushes.
+func (sw *StreamWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	if sw.opts.Array {
+		end := "]"
+		if sw.count == 0 {
+			end = "[]"
+		}
+		if _, err := io.WriteString(sw.w, end); err != nil {
+			return err
+		}
+	}
+	sw.Flush()
+	return nil
+}
+
+// WriteStream writes every value received from ch to w. It returns when ch is closed or ctx is done.
+func WriteStream(ctx context.Context, w http.ResponseWriter, code int, ch <-chan any, opts *StreamOptions) error {
+	sw := NewStreamWriter(ctx, w, code, opts)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case v, ok := <-ch:
+			if !ok {
+				return sw.Close()
+			}
+			if err := sw.Write(v); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// JSONStream decodes a streamed response one value at a time. It supports both newline delimited
+// JSON and a top level JSON array. Use it like sql.Rows:
+//
+//	s, err := gotils.DoStream(ctx, url, "GET", nil, nil)
+//	if err != nil {
+//		return err
+//	}
+//	defer s.Close()
+//	for s.Next() {
+//		v := &Thing{}
+//		if err := s.Decode(v); err != nil {
+//			return err
+//		}
+//	}
+//	return s.Err()
+type JSONStream struct {
+	ctx   context.Context
+	body  io.ReadCloser
+	br    *bufio.Reader
+	dec   *json.Decoder
+	array bool
+	begun bool
+	done  bool
+	err   error
+}
+
+// NewJSONStream returns a JSONStream reading from r.
+func NewJSONStream(ctx context.Context, r io.ReadCloser) *JSONStream {
+	return &JSONStream{ctx: ctx, body: r}
+}
+
+func (s *JSONStream) begin() error {
+	s.begun = true
+	br := bufio.NewReader(s.body)
+	s.br = br
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				s.dec = json.NewDecoder(br)
+				return nil
+			}
+			return err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+			continue
+		}
+		s.dec = json.NewDecoder(br)
+		if b[0] == '[' {
+			s.array = true
+			if _, err := s.dec.Token(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Next reports whether there is another value to decode.
+func (s *JSONStream) Next() bool {
+	if s.done || s.err != nil {
+		return false
+	}
+	if err := s.ctx.Err(); err != nil {
+		s.err = err
+		return false
+	}
+	if !s.begun {
+		if err := s.begin(); err != nil {
+			s.err = err
+			return false
+		}
+	}
+	if !s.dec.More() {
+		// More is false at the end of the input, on a read error or before an array's closing
+		// bracket, so check which it was
+		_, err := s.dec.Token()
+		if err == nil || (err == io.EOF && !s.array) {
+			s.done = true
+		} else {
+			s.err = s.truncated(err)
+		}
+		return false
+	}
+	if s.array && s.inputEnded() {
+		s.err = s.truncated(io.ErrUnexpectedEOF)
+		return false
+	}
+	return true
+}
+
+// inputEnded reports whether nothing but whitespace and commas is left of the input, which More
+// reports as another value in an array that was cut off.
+func (s *JSONStream) inputEnded() bool {
+	rest := s.dec.Buffered()
+	b := make([]byte, 1)
+	for {
+		if n, _ := rest.Read(b); n == 0 {
+			break
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n', ',':
+		default:
+			return false
+		}
+	}
+	for {
+		b, err := s.br.Peek(1)
+		if err != nil {
+			return err == io.EOF
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			// the decoder skips whitespace anyway
+			s.br.ReadByte()
+		default:
+			return false
+		}
+	}
+}
+
+// Decode decodes the next value into v.
+func (s *JSONStream) Decode(v any) error {
+	if s.dec == nil {
+		return errors.New("Decode called before Next")
+	}
+	if err := s.dec.Decode(v); err != nil {
+		s.err = s.truncated(err)
+		return s.err
+	}
+	return nil
+}
+
+// truncated turns the errors for an array that ends without its closing bracket into io.ErrUnexpectedEOF.
+func (s *JSONStream) truncated(err error) error {
+	if s.array && (err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF)) {
+		return fmt.Errorf("JSON array wasn't closed: %w", io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// Err returns the error, if any, that stopped the iteration. A stream that was cut off returns the
+// read error, or io.ErrUnexpectedEOF for an array without its closing bracket.
+func (s *JSONStream) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// Close closes the underlying response body.
+func (s *JSONStream) Close() error {
+	return s.body.Close()
+}
+
+// DoStream is like Do, but rather than decoding the whole response it returns a JSONStream to
+// read values one by one. The caller must Close the stream.
+func DoStream(ctx context.Context, url string, method string, body io.Reader, opts *RequestOptions) (*JSONStream, error) {
+	return DefaultClient.DoStream(ctx, url, method, body, opts)
+}
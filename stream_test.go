@@ -0,0 +1,107 @@
+package gotils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+type streamItem struct {
+	N int `json:"n"`
+}
+
+func TestStream(t *testing.T) {
+	for _, array := range []bool{false, true} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := NewStreamWriter(r.Context(), w, 200, &StreamOptions{Array: array, FlushEvery: 2})
+			for i := 0; i < 5; i++ {
+				if err := sw.Write(&streamItem{N: i}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			sw.Close()
+		}))
+
+		ctx := context.Background()
+		s, err := DoStream(ctx, ts.URL, "GET", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := 0
+		for s.Next() {
+			v := &streamItem{}
+			if err := s.Decode(v); err != nil {
+				t.Fatal(err)
+			}
+			if v.N != i {
+				t.Errorf("array=%v: expected %v, got %v", array, i, v.N)
+			}
+			i++
+		}
+		if s.Err() != nil {
+			t.Error(s.Err())
+		}
+		if i != 5 {
+			t.Errorf("array=%v: expected 5 items, got %v", array, i)
+		}
+		s.Close()
+		ts.Close()
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	cut := errors.New("connection reset")
+	for name, tt := range map[string]struct {
+		body    string
+		readErr error
+		want    error
+	}{
+		"ndjson read error":   {`{"n":0}` + "\n" + `{"n":1}` + "\n", cut, cut},
+		"ndjson cut in value": {`{"n":0}` + "\n" + `{"n":`, nil, io.ErrUnexpectedEOF},
+		"array not closed":    {`[{"n":0},{"n":1}`, nil, io.ErrUnexpectedEOF},
+		"array cut after ,":   {`[{"n":0},`, nil, io.ErrUnexpectedEOF},
+		"array cut after , ":  {`[{"n":0}, ` + "\n", nil, io.ErrUnexpectedEOF},
+		"array cut in value":  {`[{"n":0},{"n":`, nil, io.ErrUnexpectedEOF},
+		"array just opened":   {`[`, nil, io.ErrUnexpectedEOF},
+		"array read error":    {`[{"n":0}`, cut, cut},
+		"ndjson complete":     {`{"n":0}` + "\n" + `{"n":1}`, nil, nil},
+		"array complete":      {`[{"n":0},{"n":1}]`, nil, nil},
+	} {
+		var r io.Reader = strings.NewReader(tt.body)
+		if tt.readErr != nil {
+			r = io.MultiReader(r, iotest.ErrReader(tt.readErr))
+		}
+		s := NewJSONStream(context.Background(), io.NopCloser(r))
+		for s.Next() {
+			if err := s.Decode(&streamItem{}); err != nil {
+				break
+			}
+		}
+		if err := s.Err(); !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("%v: expected %v, got %v", name, tt.want, err)
+		}
+		// once it's done, it stays done
+		if s.Next() || !errors.Is(s.Err(), tt.want) || (tt.want == nil && s.Err() != nil) {
+			t.Errorf("%v: expected Next to stay false with %v, got %v", name, tt.want, s.Err())
+		}
+	}
+
+	// a syntax error isn't a truncated array
+	s := NewJSONStream(context.Background(), io.NopCloser(strings.NewReader(`[{"n":0} x]`)))
+	for s.Next() {
+		if err := s.Decode(&streamItem{}); err != nil {
+			break
+		}
+	}
+	var se *json.SyntaxError
+	if err := s.Err(); !errors.As(err, &se) || errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected a syntax error, got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package gotils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+// UploadOptions sets the limits for ParseUpload. Zero values use the defaults.
+type UploadOptions struct {
+	// MaxFileSize is the maximum size of each file. Defaults to 32 MB.
+	MaxFileSize int64
+	// MaxTotalSize is the maximum size of all fields and files together. Defaults to 100 MB.
+	MaxTotalSize int64
+	// AllowedTypes limits the content types of files, which are sniffed with http.DetectContentType
+	// rather than trusting what the client says. Use "image/*" to allow all images. Empty allows anything.
+	AllowedTypes []string
+	// MemoryThreshold is how big a file can get before it's spooled to a temp file instead of kept
+	// in memory. Defaults to 1 MB.
+	MemoryThreshold int64
+	// TempDir is where files are spooled, defaults to os.TempDir().
+	TempDir string
+}
+
+// Upload is a parsed multipart upload, see ParseUpload. Call RemoveAll when you're done with it to
+// delete any temp files.
+type Upload struct {
+	Fields map[string][]string
+	Files  []*UploadedFile
+}
+
+// Value returns the first value for the field name.
+func (u *Upload) Value(name string) string {
+	if v := u.Fields[name]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// File returns the first file uploaded for the field name, or nil.
+func (u *Upload) File(field string) *UploadedFile {
+	for _, f := range u.Files {
+		if f.Field == field {
+			return f
+		}
+	}
+	return nil
+}
+
+// RemoveAll deletes any temp files.
+func (u *Upload) RemoveAll() error {
+	var errs []error
+	for _, f := range u.Files {
+		if err := f.remove(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// UploadedFile is a file from an Upload.
+type UploadedFile struct {
+	Field    string
+	Filename string
+	// ContentType is sniffed from the contents.
+	ContentType string
+	// Header is the part header the client sent, including the Content-Type the client claims.
+	Header textproto.MIMEHeader
+	Size   int64
+
+	data []byte
+	path string
+}
+
+// Open returns a reader for the file contents.
+func (f *UploadedFile) Open() (io.ReadCloser, error) {
+	if f.path != "" {
+		return os.Open(f.path)
+	}
+	return io.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+// TempPath is the path of the temp file if it was spooled to disk, otherwise "".
+func (f *UploadedFile) TempPath() string {
+	return f.path
+}
+
+func (f *UploadedFile) remove() error {
+	if f.path == "" {
+		return nil
+	}
+	err := os.Remove(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	f.path = ""
+	return err
+}
+
+func (o *UploadOptions) maxFileSize() int64 {
+	if o.MaxFileSize <= 0 {
+		return 32 << 20
+	}
+	return o.MaxFileSize
+}
+
+func (o *UploadOptions) maxTotalSize() int64 {
+	if o.MaxTotalSize <= 0 {
+		return 100 << 20
+	}
+	return o.MaxTotalSize
+}
+
+func (o *UploadOptions) memoryThreshold() int64 {
+	if o.MemoryThreshold <= 0 {
+		return 1 << 20
+	}
+	return o.MemoryThreshold
+}
+
+func (o *UploadOptions) allowed(contentType string) bool {
+	if len(o.AllowedTypes) == 0 {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt = contentType
+	}
+	for _, a := range o.AllowedTypes {
+		if a == mt || a == "*/*" || (strings.HasSuffix(a, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(a, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseUpload reads a multipart/form-data request part by part, enforcing the limits in opts. Small
+// files are kept in memory and bigger ones spooled to temp files. opts can be nil for the defaults.
+//
+// Going over a size limit returns an HTTPError with code 413, a file type that isn't allowed returns 415.
+//
+//	up, err := gotils.ParseUpload(w, r, &gotils.UploadOptions{AllowedTypes: []string{"image/*"}})
+//	if err != nil {
+//		return err
+//	}
+//	defer up.RemoveAll()
+//	f, err := up.File("photo").Open()
+func ParseUpload(w http.ResponseWriter, r *http.Request, opts *UploadOptions) (*Upload, error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, NewHTTPError(fmt.Sprintf("invalid multipart request: %v", err), http.StatusBadRequest)
+	}
+	up := &Upload{Fields: map[string][]string{}}
+	remaining := opts.maxTotalSize()
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return up, nil
+		}
+		if err != nil {
+			up.RemoveAll()
+			return nil, NewHTTPError(fmt.Sprintf("invalid multipart request: %v", err), http.StatusBadRequest)
+		}
+		if part.FileName() == "" {
+			b, err := io.ReadAll(io.LimitReader(part, remaining+1))
+			part.Close()
+			if err != nil {
+				up.RemoveAll()
+				return nil, C(r.Context()).Errorf("couldn't read field %q: %w", part.FormName(), err)
+			}
+			remaining -= int64(len(b))
+			if remaining < 0 {
+				up.RemoveAll()
+				return nil, NewHTTPError("upload too large", http.StatusRequestEntityTooLarge)
+			}
+			up.Fields[part.FormName()] = append(up.Fields[part.FormName()], string(b))
+			continue
+		}
+		f, err := readUploadedFile(part, opts, remaining)
+		part.Close()
+		if err != nil {
+			up.RemoveAll()
+			return nil, err
+		}
+		remaining -= f.Size
+		up.Files = append(up.Files, f)
+	}
+}
+
+func readUploadedFile(part *multipart.Part, opts *UploadOptions, remaining int64) (*UploadedFile, error) {
+	f := &UploadedFile{Field: part.FormName(), Filename: part.FileName(), Header: part.Header}
+	limit := opts.maxFileSize()
+	if remaining < limit {
+		limit = remaining
+	}
+	// sniff the type from the first 512 bytes
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(part, sniff)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	sniff = sniff[:n]
+	f.ContentType = http.DetectContentType(sniff)
+	if !opts.allowed(f.ContentType) {
+		return nil, NewHTTPError(fmt.Sprintf("file type %v is not allowed", f.ContentType), http.StatusUnsupportedMediaType)
+	}
+
+	tooLarge := NewHTTPError(fmt.Sprintf("file %q is too large", f.Filename), http.StatusRequestEntityTooLarge)
+	r := io.LimitReader(io.MultiReader(bytes.NewReader(sniff), part), limit+1)
+	buf := &bytes.Buffer{}
+	n2, err := io.Copy(buf, io.LimitReader(r, opts.memoryThreshold()))
+	if err != nil {
+		return nil, err
+	}
+	f.Size = n2
+	if n2 < opts.memoryThreshold() {
+		if f.Size > limit {
+			return nil, tooLarge
+		}
+		f.data = buf.Bytes()
+		return f, nil
+	}
+	// too big for memory, spool to disk
+	tmp, err := os.CreateTemp(opts.TempDir, "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	f.path = tmp.Name()
+	n3, err := io.Copy(tmp, io.MultiReader(buf, r))
+	tmp.Close()
+	if err != nil {
+		f.remove()
+		return nil, err
+	}
+	f.Size = n3
+	if f.Size > limit {
+		f.remove()
+		return nil, tooLarge
+	}
+	return f, nil
+}
@@ -0,0 +1,210 @@
+package gotils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseUpload(t *testing.T) {
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), make([]byte, 2000)...)
+	var tempPath string
+	ts := httptest.NewServer(ErrorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		up, err := ParseUpload(w, r, &UploadOptions{
+			MaxFileSize:     4000,
+			AllowedTypes:    []string{"image/*"},
+			MemoryThreshold: 1000,
+		})
+		if err != nil {
+			return err
+		}
+		defer up.RemoveAll()
+		f := up.File("photo")
+		tempPath = f.TempPath()
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		b, _ := io.ReadAll(rc)
+		return WriteObject(w, 200, map[string]any{"name": up.Value("name"), "type": f.ContentType, "size": f.Size, "read": len(b)})
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	out := map[string]any{}
+	err := PostMultipart(ctx, ts.URL, map[string]string{"name": "cat"}, []*FormFile{
+		{Field: "photo", Filename: "cat.png", Reader: bytes.NewReader(png)},
+	}, &out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["name"] != "cat" || out["type"] != "image/png" || out["size"] != float64(len(png)) || out["read"] != float64(len(png)) {
+		t.Errorf("unexpected upload %v", out)
+	}
+	if tempPath == "" {
+		t.Error("expected file to be spooled to disk")
+	} else if _, err := os.Stat(tempPath); !os.IsNotExist(err) {
+		t.Error("expected temp file to be removed")
+	}
+
+	var he HTTPError
+	err = PostMultipart(ctx, ts.URL, nil, []*FormFile{
+		{Field: "photo", Filename: "big.png", Reader: bytes.NewReader(append(png, make([]byte, 4000)...))},
+	}, nil, nil)
+	if !errors.As(err, &he) || he.Code() != http.StatusRequestEntityTooLarge {
+		t.Error("expected 413, got", err)
+	}
+	err = PostMultipart(ctx, ts.URL, nil, []*FormFile{
+		{Field: "photo", Filename: "cat.png", ContentType: "image/png", Reader: strings.NewReader("not really a png")},
+	}, nil, nil)
+	if !errors.As(err, &he) || he.Code() != http.StatusUnsupportedMediaType {
+		t.Error("expected 415, got", err)
+	}
+}
+
+type uploadPart struct {
+	field, filename string
+	data            []byte
+}
+
+func multipartBody(t *testing.T, parts ...uploadPart) ([]byte, string) {
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	for _, p := range parts {
+		var w io.Writer
+		var err error
+		if p.filename == "" {
+			w, err = mw.CreateFormField(p.field)
+		} else {
+			w, err = mw.CreateFormFile(p.field, p.filename)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(p.data)
+	}
+	mw.Close()
+	return buf.Bytes(), mw.FormDataContentType()
+}
+
+func uploadRequest(body []byte, contentType string) *http.Request {
+	r := httptest.NewRequest("POST", "/upload", bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	return r
+}
+
+func TestParseUploadLimits(t *testing.T) {
+	text := func(n int) []byte { return bytes.Repeat([]byte("a"), n) }
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), make([]byte, 100)...)
+	opts := &UploadOptions{MaxFileSize: 1000, MaxTotalSize: 1500, TempDir: t.TempDir()}
+	tests := []struct {
+		name  string
+		opts  *UploadOptions
+		parts []uploadPart
+		code  int
+	}{
+		{"under the limits", opts, []uploadPart{{"name", "", text(100)}, {"f", "a.txt", text(1000)}}, 0},
+		{"file too large", opts, []uploadPart{{"f", "a.txt", text(1001)}}, 413},
+		{"fields too large", opts, []uploadPart{{"name", "", text(1501)}}, 413},
+		{"files too large together", opts, []uploadPart{{"f", "a.txt", text(800)}, {"g", "b.txt", text(800)}}, 413},
+		{"fields and files too large together", opts, []uploadPart{{"name", "", text(600)}, {"f", "a.txt", text(1000)}}, 413},
+		{"allowed type", &UploadOptions{AllowedTypes: []string{"image/png"}}, []uploadPart{{"f", "a.png", png}}, 0},
+		{"type not allowed", &UploadOptions{AllowedTypes: []string{"image/*"}}, []uploadPart{{"f", "a.png", text(10)}}, 415},
+	}
+	for _, tt := range tests {
+		body, ct := multipartBody(t, tt.parts...)
+		up, err := ParseUpload(httptest.NewRecorder(), uploadRequest(body, ct), tt.opts)
+		if tt.code == 0 {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", tt.name, err)
+			} else {
+				up.RemoveAll()
+			}
+			continue
+		}
+		var he HTTPError
+		if !errors.As(err, &he) || he.Code() != tt.code {
+			t.Errorf("%v: expected %v, got %v", tt.name, tt.code, err)
+		}
+	}
+
+	if _, err := ParseUpload(httptest.NewRecorder(), uploadRequest([]byte("{}"), "application/json"), nil); err == nil {
+		t.Error("expected an error for a request that isn't multipart")
+	}
+}
+
+func TestParseUploadSpooling(t *testing.T) {
+	dir := t.TempDir()
+	opts := &UploadOptions{MemoryThreshold: 1000, TempDir: dir}
+	for size, spooled := range map[int]bool{0: false, 999: false, 1000: true, 5000: true} {
+		data := bytes.Repeat([]byte("b"), size)
+		body, ct := multipartBody(t, uploadPart{"f", "b.txt", data})
+		up, err := ParseUpload(httptest.NewRecorder(), uploadRequest(body, ct), opts)
+		if err != nil {
+			t.Fatal(size, err)
+		}
+		f := up.File("f")
+		if (f.TempPath() != "") != spooled {
+			t.Errorf("%v bytes: expected spooled to be %v, got temp path %q", size, spooled, f.TempPath())
+		}
+		if spooled && !strings.HasPrefix(f.TempPath(), dir) {
+			t.Errorf("%v bytes: expected the temp file in %v, got %v", size, dir, f.TempPath())
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		if !bytes.Equal(b, data) || f.Size != int64(size) {
+			t.Errorf("%v bytes: read back %v bytes, size %v", size, len(b), f.Size)
+		}
+		path := f.TempPath()
+		if err := up.RemoveAll(); err != nil {
+			t.Fatal(err)
+		}
+		if path != "" {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("%v bytes: expected the temp file to be removed", size)
+			}
+		}
+	}
+}
+
+func TestParseUploadCleanup(t *testing.T) {
+	big := bytes.Repeat([]byte("c"), 3000)
+	for name, body := range map[string]func() ([]byte, string){
+		"file too large": func() ([]byte, string) {
+			return multipartBody(t, uploadPart{"a", "a.txt", big}, uploadPart{"b", "b.txt", append(big, big...)})
+		},
+		"type not allowed": func() ([]byte, string) {
+			return multipartBody(t, uploadPart{"a", "a.txt", big}, uploadPart{"b", "b.png", []byte("\x00\x01binary")})
+		},
+		"cut off": func() ([]byte, string) {
+			b, ct := multipartBody(t, uploadPart{"a", "a.txt", big}, uploadPart{"b", "b.txt", big})
+			return b[:len(b)-1000], ct
+		},
+	} {
+		dir := t.TempDir()
+		opts := &UploadOptions{MaxFileSize: 5000, MemoryThreshold: 1000, AllowedTypes: []string{"text/*"}, TempDir: dir}
+		b, ct := body()
+		if _, err := ParseUpload(httptest.NewRecorder(), uploadRequest(b, ct), opts); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("%v: expected temp files to be removed, found %v", name, len(entries))
+		}
+	}
+}